@@ -8,87 +8,117 @@ import (
 	"time"
 
 	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
 )
 
+// stats is the usage of one container over the stats window.
 type stats struct {
 	cpuPct, memPct float64
+	throttledPct   float64
 	err            error
 }
 
-// Active Monitoring Load Balancer (AMLB) periodically monitors the containers and decides whether to scale up or down
-// based on CPU and memory usage. It enforces the minimum and maximum replica counts
-// and performs a single scaling action at a time. The check is done every ScaleInterval (default is 15s).
-func AMLB() {
-	ticker := time.NewTicker(config.ScaleIntervalAM)
-	defer ticker.Stop()
-
-	log.Printf("i'm called AMLB")
-
-	for range ticker.C {
-		// 1) Snapshot current state under lock
-		config.BackendsMu.Lock()
-		currentReplicas := config.Backends.Len()
-		containerIDs := make([]string, config.Backends.Len())
-		for i, be := range config.Backends {
-			containerIDs[i] = be.ContainerID
-		}
-		config.BackendsMu.Unlock()
+// resourceSignal is the Active Monitoring (AM) part of the scaling: it samples the CPU and
+// memory usage of the containers every ScaleIntervalAM, averaged over the stats window.
+// With a TargetCPUPercent it sizes the pool so the average cpu lands on it, otherwise it asks
+// for one more replica when most of them run hot, one less when nearly all are idle.
+type resourceSignal struct {
+	// sampledAt, desired and reason hold the last sample, the recommendation is computed
+	// from the replica count it was taken with so it isn't applied twice
+	sampledAt time.Time
+	desired   int
+	reason    string
+}
 
-		// Skip stats collection if there are no containers
-		if len(containerIDs) == 0 {
-			log.Printf("no containers to monitor")
-			continue
+func (*resourceSignal) Name() string { return "resources" }
+
+func (s *resourceSignal) Recommend(current int) (structers.ScalingRecommendation, error) {
+	if time.Since(s.sampledAt) >= config.ScaleIntervalAM {
+		if err := s.sample(current); err != nil {
+			return structers.ScalingRecommendation{}, err
 		}
+	}
 
-		results := make([]stats, len(containerIDs))
+	return structers.ScalingRecommendation{
+		Desired: s.desired,
+		Reason:  s.reason,
+	}, nil
+}
 
-		appendResults(containerIDs, &results)
+// sample collects the stats of the replicas in rotation and derives the recommendation
+// for current replicas, counted like for the other signals (the starting ones included).
+func (s *resourceSignal) sample(current int) error {
+	// 1) Snapshot current state under lock
+	config.BackendsMu.Lock()
+	containerIDs := make([]string, config.Backends.Len())
+	for i, be := range config.Backends {
+		containerIDs[i] = be.ContainerID
+	}
+	config.BackendsMu.Unlock()
 
-		// 3) Determine if we need to scale up or down once
-		scaleUpCount := 0
-		scaleDownCount := 0
-		// i created this var just as a guard, i already start at n InitialReplicas
-		validCont := 0
+	// Skip stats collection if there are no containers
+	if len(containerIDs) == 0 {
+		return fmt.Errorf("no containers to monitor")
+	}
 
-		for _, r := range results {
-			if r.err != nil {
-				// log and skip this container
-				log.Printf("failed to inspect %+v: %v", r, r.err)
-				continue
-			}
+	results := make([]stats, len(containerIDs))
 
-			validCont++
+	appendResults(containerIDs, &results)
 
-			if r.cpuPct > 75.0 || r.memPct > 80.0 {
-				scaleUpCount++
-			}
-			if r.cpuPct < 40.0 && r.memPct < 50.0 {
-				scaleDownCount++
-			}
-		}
+	// 2) Count the hot and the idle containers
+	scaleUpCount := 0
+	scaleDownCount := 0
+	// i created this var just as a guard, i already start at n InitialReplicas
+	validCont := 0
+	cpuSum := 0.0
 
-		if validCont == 0 {
-			log.Printf("no valid containers")
+	for _, r := range results {
+		if r.err != nil {
+			// log and skip this container
+			log.Printf("failed to inspect %+v: %v", r, r.err)
 			continue
 		}
 
-		upRatio := float64(scaleUpCount) / float64(validCont)
-		downRatio := float64(scaleDownCount) / float64(validCont)
+		validCont++
+		cpuSum += r.cpuPct
 
-		if upRatio > 0.6 && currentReplicas < config.MaxReplicas {
-			ScaleUp()
-		} else if downRatio > 0.8 && currentReplicas > config.MinReplicas {
-			ScaleDown()
+		if r.cpuPct > 75.0 || r.memPct > 80.0 || r.throttledPct > config.ThrottledHotPercent {
+			scaleUpCount++
+		}
+		if r.cpuPct < 40.0 && r.memPct < 50.0 {
+			scaleDownCount++
 		}
 	}
+
+	if validCont == 0 {
+		return fmt.Errorf("no valid containers")
+	}
+
+	upRatio := float64(scaleUpCount) / float64(validCont)
+	downRatio := float64(scaleDownCount) / float64(validCont)
+
+	replicas := current
+	s.sampledAt = time.Now()
+	switch {
+	case config.TargetCPUPercent > 0:
+		avg := cpuSum / float64(validCont)
+		s.desired = proportionalReplicas(replicas, avg, config.TargetCPUPercent)
+		s.reason = fmt.Sprintf("cpu=%.1f%% over %d replicas, target %.0f%%", avg, validCont, config.TargetCPUPercent)
+	case upRatio > 0.6:
+		s.desired = replicas + 1
+		s.reason = fmt.Sprintf("%d/%d replicas over 75%% cpu, 80%% mem or %.0f%% throttled", scaleUpCount, validCont, config.ThrottledHotPercent)
+	case downRatio > 0.8:
+		s.desired = replicas - 1
+		s.reason = fmt.Sprintf("%d/%d replicas under 40%% cpu and 50%% mem", scaleDownCount, validCont)
+	default:
+		s.desired = replicas
+		s.reason = fmt.Sprintf("%d/%d replicas hot, %d/%d idle", scaleUpCount, validCont, scaleDownCount, validCont)
+	}
+	return nil
 }
 
-// appendResults iterates over a list of container IDs, collects CPU and memory
-// usage statistics for each container by calling GetInfoAboutReplica, and
-// stores the results in the provided stats slice. If an error occurs while
-// fetching stats or the container ID is empty, it logs the error and sets
-// default values for the CPU and memory percentages in the results slice.
-// This function is intended to run concurrently and updates the results slice in place.
+// appendResults fills results with the rolling cpu and memory usage of each container, read
+// from the stats collector cache. A container without fresh stats gets its error instead.
 func appendResults(containerIDs []string, results *[]stats) {
 	for i, cid := range containerIDs {
 		if cid == "" {
@@ -100,32 +130,17 @@ func appendResults(containerIDs []string, results *[]stats) {
 			}
 			continue
 		}
-		info, err := GetInfoAboutReplica(cid)
 
+		v, err := cachedStats(cid)
 		if err != nil {
 			log.Printf("error getting stats for container %s: %v", cid, err)
-			(*results)[i] = stats{
-				cpuPct: 0,
-				memPct: 0,
-				err:    err,
-			}
-			continue
-		}
-
-		// Check if info is nil to prevent panic
-		if info == nil {
-			log.Printf("received nil info for container %s", cid)
-			(*results)[i] = stats{
-				cpuPct: 0,
-				memPct: 0,
-				err:    err,
-			}
+			(*results)[i] = stats{err: err}
 			continue
 		}
 		(*results)[i] = stats{
-			cpuPct: info.CPUPercent,
-			memPct: info.MemoryPercent,
-			err:    err,
+			cpuPct:       v.CPUAvg,
+			memPct:       v.MemoryAvg,
+			throttledPct: v.ThrottledAvg,
 		}
 	}
 }
@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// seedStats files backends in rotation whose stats streams report the given cpu usage.
+func seedStats(t *testing.T, cpuPercent ...float64) {
+	t.Helper()
+	t.Cleanup(func() {
+		resetPool()
+		statsMu.Lock()
+		statsStreams = make(map[string]*statsStream)
+		statsMu.Unlock()
+	})
+
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	for i, cpu := range cpuPercent {
+		id := fmt.Sprintf("stats-%d", i)
+		u, _ := url.Parse("http://localhost:1")
+		heap.Push(&config.Backends, &structers.Backend{URL: u, ContainerID: id, Alive: true, Pool: config.ParentName})
+		statsStreams[id] = &statsStream{
+			cancel:  func() {},
+			samples: []structers.ReplicaStats{{CPUPercent: cpu, MemoryPercent: 10, Read: time.Now()}},
+			updated: time.Now(),
+		}
+	}
+}
+
+func TestResourceSignalCountsStartingReplicas(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     []float64
+		current int
+		want    int
+	}{
+		{name: "on target", cpu: []float64{60, 60}, current: 2, want: 2},
+		// the two starting replicas aren't a reason to scale down
+		{name: "on target with replicas starting", cpu: []float64{60, 60}, current: 4, want: 4},
+		{name: "hot", cpu: []float64{90, 90}, current: 2, want: 3},
+		{name: "idle", cpu: []float64{15, 15}, current: 2, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seedStats(t, tt.cpu...)
+			var s resourceSignal
+			rec, err := s.Recommend(tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Desired != tt.want {
+				t.Errorf("desired = %d (%s), want %d", rec.Desired, rec.Reason, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+)
+
+var (
+	// activating is 1 while a replica is being cold started from zero
+	activating int32
+)
+
+func init() {
+	metrics.Describe("lb_cold_starts_total", metrics.Counter, "Activations of the pool from zero replicas.")
+	metrics.Describe("lb_cold_start_failures_total", metrics.Counter, "Activations that didn't get a ready replica in time.")
+	metrics.Describe("lb_cold_start_seconds_total", metrics.Counter, "Total time spent cold starting the pool.")
+	metrics.Describe("lb_cold_start_last_seconds", metrics.Gauge, "Duration of the last successful cold start.")
+}
+
+// coldStarting reports whether the pool is scaled to zero or being activated,
+// the requests are then held for ActivationTimeout instead of BackendQueueTimeout.
+func coldStarting() bool {
+	if atomic.LoadInt32(&activating) == 1 {
+		return true
+	}
+	if floor, _ := replicaBounds(time.Now()); floor > 0 {
+		return false
+	}
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+	return config.Backends.Len()+len(config.Unhealthy) == 0
+}
+
+// activateFromZero starts one replica for a pool scaled to zero and waits for it to pass
+// its startup probe, the queued requests are released by markReady. Only one activation
+// runs at a time, the other callers just wait for it. A dry-run pool stays at zero.
+func activateFromZero() {
+	if DryRunEnabled(config.ParentName) {
+		dryRunActivation()
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&activating, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&activating, 0)
+
+		start := time.Now()
+		log.Printf("cold start: pool is scaled to zero, activating a replica")
+		ScaleUp()
+
+		deadline := start.Add(config.ActivationTimeout)
+		for time.Now().Before(deadline) {
+			if hasHealthyBackend() {
+				took := time.Since(start)
+				log.Printf("cold start: replica ready after %v", took)
+				metrics.Inc("lb_cold_starts_total")
+				metrics.Add("lb_cold_start_seconds_total", took.Seconds())
+				metrics.Set("lb_cold_start_last_seconds", took.Seconds())
+				return
+			}
+
+			// the startup probe of the new replica brings it in the heap
+			time.Sleep(config.ActivationProbeInterval)
+		}
+
+		log.Printf("cold start: no replica ready after %v", config.ActivationTimeout)
+		metrics.Inc("lb_cold_start_failures_total")
+	}()
+}
+
+func hasHealthyBackend() bool {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+	return config.Backends.Len() > 0
+}
+
+// lastRequestTime returns when the last request was proxied.
+func lastRequestTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&config.LastRequestAt))
+}
@@ -0,0 +1,113 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// AdminHandler returns the mux of the admin server, it exposes the internal state
+// of the load balancer and is served on config.AdminAddr, away from the proxied traffic.
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	mux.HandleFunc("GET /admin/mirror", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, structers.MirrorStats{
+			Mirrored:       atomic.LoadInt64(&config.Mirror.Mirrored),
+			Skipped:        atomic.LoadInt64(&config.Mirror.Skipped),
+			Failed:         atomic.LoadInt64(&config.Mirror.Failed),
+			Compared:       atomic.LoadInt64(&config.Mirror.Compared),
+			StatusMismatch: atomic.LoadInt64(&config.Mirror.StatusMismatch),
+			LatencyDiffNs:  atomic.LoadInt64(&config.Mirror.LatencyDiffNs),
+		})
+	})
+
+	// health transitions of every backend, or of one with ?container=<id>
+	mux.HandleFunc("GET /admin/history", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, healthHistory(r.URL.Query().Get("container")))
+	})
+
+	mux.HandleFunc("GET /admin/dependencies", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, dependencyStatus())
+	})
+
+	mux.HandleFunc("POST /admin/dependencies/{name}/restart", func(w http.ResponseWriter, r *http.Request) {
+		if err := RestartDependency(r.PathValue("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]string{"restarted": r.PathValue("name")})
+	})
+
+	mux.HandleFunc("GET /admin/events", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, recentEvents())
+	})
+
+	// every scaling evaluation: the recommendations, the winning signal and the action
+	mux.HandleFunc("GET /admin/scaling", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, recentScalingDecisions())
+	})
+
+	mux.HandleFunc("GET /admin/dryrun", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, dryRunStatus())
+	})
+
+	// switch the dry-run mode of a pool with ?enabled=true|false
+	mux.HandleFunc("POST /admin/dryrun/{pool}", func(w http.ResponseWriter, r *http.Request) {
+		on, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		if err := SetDryRun(r.PathValue("pool"), on); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, dryRunStatus())
+	})
+
+	// the request rate model of the predictive scaling and its accuracy
+	mux.HandleFunc("GET /admin/forecast", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, forecastStatus())
+	})
+
+	// the scaling schedules, the open ones and the bounds in effect
+	mux.HandleFunc("GET /admin/schedules", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, replicaBoundsStatus(time.Now()))
+	})
+
+	// the rolling cpu and memory usage of the replicas, as the resource signal sees it
+	mux.HandleFunc("GET /admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, replicaStatsStatus())
+	})
+
+	mux.HandleFunc("GET /admin/remediation", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]bool{"crash_looping": CrashLooping()})
+	})
+
+	mux.HandleFunc("POST /admin/remediation/reset", func(w http.ResponseWriter, r *http.Request) {
+		ResetCrashLoop()
+		writeJSON(w, map[string]bool{"crash_looping": false})
+	})
+
+	return mux
+}
+
+// writeJSON encodes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin: encode response: %v", err)
+	}
+}
@@ -3,45 +3,94 @@
 package functions
 
 import (
+	"fmt"
 	"log"
-	"sync/atomic"
+	"math"
 	"time"
 
 	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
 )
 
-// AutoScaler periodically checks the request volume and adjusts the number of
-// replicas according to the configured thresholds. If the request count is
-// greater than the scale up threshold and there are less than the maximum number
-// of replicas, it starts a new replica. If the request count is less than the
-// scale down threshold and there are more than the minimum number of replicas, it
-// removes one replica. The check is done every ScaleInterval (default is 15s).
+// AutoScaler is the scaling controller of the pool. Every ScaleInterval (default is 15s) it
+// asks each scaling signal (request rate, latency, CPU and memory usage) how many replicas
+// it wants, combines them under config.ScalingPolicy, and scales straight to the result
+// unless the direction is in cooldown. Every decision is recorded with the signal that won and why.
 func AutoScaler() {
 	ticker := time.NewTicker(config.ScaleInterval)
 	defer ticker.Stop()
 
 	log.Printf("i'm called AutoScaler")
 
-	// Every interval (15s in our case), check request volume and adjust replicas.
 	for range ticker.C {
-		// 1) Snapshot and reset request count atomically
-		count := atomic.SwapInt64(&config.ReqCount, 0)
-
-		config.BackendsMu.Lock()
-		replicas := config.Backends.Len()
-		config.BackendsMu.Unlock()
-		switch {
-		// for every set time interval(15s for example) this check will be triggered
-		// the check will see if the reqCount has passed scale up threshold req
-		case count > int64(config.ScaleUpThreshold) && replicas < config.MaxReplicas:
-			// scale up inline
-			ScaleUp()
-		// same in here but it will check if reqCount is less than the scale down threshold
-		case count < int64(config.ScaleDownThreshold) && replicas > config.MinReplicas:
-			//scale down inline
-			ScaleDown()
+		if DependencyDegraded() {
+			// still drain the request counter and the load samples, so the outage isn't
+			// read as a burst afterwards
+			takeRequestCount()
+			takeLoad()
+			log.Printf("pool is dependency degraded, no scaling")
+			continue
 		}
 
-		
+		applyScalingDecision(evaluateScaling())
 	}
 }
+
+// requestSignal recommends from the request volume since the last evaluation and the
+// requests queued at the concurrency limiter.
+type requestSignal struct {
+	// lastAt is when the request counter was last reset
+	lastAt time.Time
+}
+
+func (*requestSignal) Name() string { return "requests" }
+
+// Recommend snapshots and resets the request counter. With a TargetRPSPerReplica the
+// replica count is set so each one gets the target rate, otherwise it's one more
+// replica above ScaleUpThreshold and one less below ScaleDownThreshold.
+func (s *requestSignal) Recommend(current int) (structers.ScalingRecommendation, error) {
+	count := takeRequestCount()
+	now := time.Now()
+	elapsed := config.ScaleInterval
+	if !s.lastAt.IsZero() {
+		elapsed = now.Sub(s.lastAt)
+	}
+	s.lastAt = now
+
+	// requests waiting at the front door mean the pool is saturated whatever the volume
+	if _, _, queued := ConcurrencyState(); queued > 0 {
+		return structers.ScalingRecommendation{
+			Desired: current + 1,
+			Reason:  fmt.Sprintf("%d requests queued at the concurrency limiter", queued),
+		}, nil
+	}
+
+	if config.TargetRPSPerReplica > 0 {
+		rps := float64(count) / elapsed.Seconds()
+		desired := int(math.Ceil(rps / config.TargetRPSPerReplica))
+		if current > 0 {
+			desired = proportionalReplicas(current, rps/float64(current), config.TargetRPSPerReplica)
+		}
+		return structers.ScalingRecommendation{
+			Desired: desired,
+			Reason:  fmt.Sprintf("rps=%.1f over %d replicas, target %.0f per replica", rps, current, config.TargetRPSPerReplica),
+		}, nil
+	}
+
+	switch {
+	case count > int64(config.ScaleUpThreshold):
+		return structers.ScalingRecommendation{
+			Desired: current + 1,
+			Reason:  fmt.Sprintf("reqs=%d > %d", count, config.ScaleUpThreshold),
+		}, nil
+	case count < int64(config.ScaleDownThreshold):
+		return structers.ScalingRecommendation{
+			Desired: max(current-1, 0),
+			Reason:  fmt.Sprintf("reqs=%d < %d", count, config.ScaleDownThreshold),
+		}, nil
+	}
+	return structers.ScalingRecommendation{
+		Desired: current,
+		Reason:  fmt.Sprintf("reqs=%d within [%d, %d]", count, config.ScaleDownThreshold, config.ScaleUpThreshold),
+	}, nil
+}
@@ -9,63 +9,77 @@ import (
 	"github.com/xaydras-2/loadBalancer/App/structers"
 )
 
-func snapshotAllBackends() []*structers.Backend {
-	config.BackendsMu.Lock()
-	defer config.BackendsMu.Unlock()
-
-	all := make([]*structers.Backend, 0, config.Backends.Len()+len(config.Unhealthy))
-	all = append(all, config.Backends...)
-	all = append(all, config.Unhealthy...)
-
-	config.Unhealthy = nil
-	return all
-}
-
+// handleRecoveredBackend counts a successful probe, and puts the backend back in
+// rotation once it reached the HealthyThreshold of its pool.
 func handleRecoveredBackend(b *structers.Backend) {
+	b.ConsecutiveFails = 0
+	b.ConsecutiveOKs++
+
 	if !b.Alive || b.Ill {
+		if b.ConsecutiveOKs < healthSpecFor(b).HealthyThreshold {
+			return
+		}
 		b.Alive = true
 		b.Ill = false
-		// // Only add to heap if not already there
-		// if b.HeapIdx < 0 || b.HeapIdx >= config.Backends.Len() {
-		// 	heap.Push(&config.Backends, b)
-		// } else {
-		// 	// Already in heap, just fix position
-		// 	heap.Fix(&config.Backends, b.HeapIdx)
-		// }
-		heap.Push(&config.Backends, b)
+		b.DeadSince = time.Time{}
+		b.ProbeBackoff = 0
+		removeFromUnHealthy(b)
+		// Only add to heap if not already there (an ill backend may still be in it)
+		if inHeap(b) {
+			heap.Fix(&config.Backends, b.HeapIdx)
+		} else {
+			heap.Push(&config.Backends, b)
+		}
+		// release the requests queued while no backend was healthy
+		notifyBackendAvailable()
 	}
 }
+
+// handleFailingBackend counts a failed probe: the first failure makes the backend ill,
+// reaching the UnhealthyThreshold of its pool makes it dead.
 func handleFailingBackend(b *structers.Backend) {
+	b.ConsecutiveOKs = 0
+	b.ConsecutiveFails++
+
 	if !b.Ill {
 		// first failure -> go “ill”
 		b.Ill = true
 		log.Printf("Backend %s marked as ill", b.URL.String())
+	}
 
-		// Fix heap position since Ill status affects ordering
-		if b.HeapIdx >= 0 && b.HeapIdx < config.Backends.Len() {
-			heap.Fix(&config.Backends, b.HeapIdx)
+	if b.ConsecutiveFails >= healthSpecFor(b).UnhealthyThreshold {
+		// enough consecutive fails -> dead, it passed its startup probe already
+		b.Alive = false
+		b.Ill = false
+		b.DeadSince = time.Now()
+		scheduleDeadProbe(b)
+		log.Printf("Backend %s marked as dead after %d failed checks", b.URL.String(), b.ConsecutiveFails)
+		// Remove from active heap since it's now dead
+		if inHeap(b) {
+			heap.Remove(&config.Backends, b.HeapIdx)
 		}
-	} else {
-		// second consecutive fail -> only kill if grace has passed
-		if time.Since(b.StartTime) < config.StartupGracePeriod {
-			log.Printf(
-				"Backend %s still starting (%.0fs), postponing death",
-				b.URL.String(),
-				time.Since(b.StartTime).Seconds(),
-			)
+		// It will be added to unhealthy list by the caller
+		return
+	}
 
-		} else {
-			b.Alive = false
-			b.Ill = false
-			log.Printf("Backend %s marked as dead", b.URL.String())
-			// Remove from active heap since it's now dead
-			if b.HeapIdx >= 0 && b.HeapIdx < config.Backends.Len() {
-				heap.Remove(&config.Backends, b.HeapIdx)
-			}
-			// It will be added to unhealthy list by the caller
-		}
+	// fix heap so its position / LOD updates, since Ill status affects ordering
+	if inHeap(b) {
+		heap.Fix(&config.Backends, b.HeapIdx)
 	}
+}
+
+// inHeap reports whether b currently sits in config.Backends. config.BackendsMu must be held.
+func inHeap(b *structers.Backend) bool {
+	return b.HeapIdx >= 0 && b.HeapIdx < config.Backends.Len() && config.Backends[b.HeapIdx] == b
+}
 
-	// fix heap so its position / LOD updates
-	heap.Fix(&config.Backends, b.HeapIdx)
+// scheduleDeadProbe pushes the next probe of a dead backend further away each time,
+// doubling from the pool interval up to DeadProbeMaxBackoff.
+func scheduleDeadProbe(b *structers.Backend) {
+	if b.ProbeBackoff == 0 {
+		b.ProbeBackoff = healthSpecFor(b).Interval
+	} else {
+		b.ProbeBackoff = min(2*b.ProbeBackoff, config.DeadProbeMaxBackoff)
+	}
+	b.NextProbe = time.Now().Add(b.ProbeBackoff)
 }
@@ -0,0 +1,180 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"container/heap"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	errQueueFull    = errors.New("backend queue full")
+	errQueueTimeout = errors.New("timed out waiting for a backend")
+
+	// backendQueueMu guards backendQueue and backendQueueSeq
+	backendQueueMu  sync.Mutex
+	backendQueue    waitQueue
+	backendQueueSeq uint64
+
+	// backendAvailable wakes DispatchQueuedRequests up when a backend (re)joins the heap
+	backendAvailable = make(chan struct{}, 1)
+)
+
+// queuedRequest is one request waiting for a backend.
+type queuedRequest struct {
+	priority int
+	seq      uint64
+	idx      int                     // position in the queue, -1 once handed a backend
+	ch       chan *structers.Backend // receives the picked backend, buffered
+}
+
+// waitQueue orders the waiting requests by priority (highest first) then by arrival.
+// It implements heap.Interface.
+type waitQueue []*queuedRequest
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].idx, q[j].idx = i, j
+}
+
+func (q *waitQueue) Push(x any) {
+	qr := x.(*queuedRequest)
+	qr.idx = len(*q)
+	*q = append(*q, qr)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	n := len(old)
+	qr := old[n-1]
+	qr.idx = -1
+	*q = old[:n-1]
+	return qr
+}
+
+func init() {
+	metrics.GaugeFunc("lb_backend_queue_depth", "Requests waiting for a healthy backend.", func() float64 {
+		backendQueueMu.Lock()
+		defer backendQueueMu.Unlock()
+		return float64(backendQueue.Len())
+	})
+	metrics.Describe("lb_backend_queue_wait_seconds_total", metrics.Counter, "Total time requests spent waiting for a backend.")
+	metrics.Describe("lb_backend_queue_released_total", metrics.Counter, "Queued requests that got a backend.")
+	metrics.Describe("lb_backend_queue_rejected_total", metrics.Counter, "Requests that couldn't wait for a backend.")
+}
+
+// notifyBackendAvailable wakes the dispatcher up without ever blocking,
+// it's safe to call with config.BackendsMu held.
+func notifyBackendAvailable() {
+	select {
+	case backendAvailable <- struct{}{}:
+	default:
+		// a wake up is already pending
+	}
+}
+
+// DispatchQueuedRequests hands the healthy backends over to the queued requests,
+// in queue order, as soon as they're available. Meant to run in its own goroutine.
+func DispatchQueuedRequests() {
+	// safety net in case a wake up is missed
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-backendAvailable:
+		case <-ticker.C:
+		}
+		dispatchQueuedRequests()
+	}
+}
+
+func dispatchQueuedRequests() {
+	backendQueueMu.Lock()
+	defer backendQueueMu.Unlock()
+
+	for backendQueue.Len() > 0 {
+		b := pickBackendAndIncrement()
+		if b == nil {
+			return
+		}
+		qr := heap.Pop(&backendQueue).(*queuedRequest)
+		qr.ch <- b
+	}
+}
+
+// waitForBackend queues the request until a backend is available, the queue is full,
+// the request waited timeout or the client went away.
+// The returned backend has already been counted in its CurrentLoad.
+func waitForBackend(r *http.Request, timeout time.Duration) (*structers.Backend, time.Duration, error) {
+	priority := 0
+	if config.BackendQueueOrder == "priority" {
+		priority, _ = strconv.Atoi(r.Header.Get(config.BackendQueuePriorityHeader))
+	}
+
+	backendQueueMu.Lock()
+	if backendQueue.Len() >= config.BackendQueueMaxLength {
+		backendQueueMu.Unlock()
+		metrics.Inc("lb_backend_queue_rejected_total", "reason", "full")
+		return nil, 0, errQueueFull
+	}
+	backendQueueSeq++
+	qr := &queuedRequest{priority: priority, seq: backendQueueSeq, ch: make(chan *structers.Backend, 1)}
+	heap.Push(&backendQueue, qr)
+	backendQueueMu.Unlock()
+
+	// a backend may have shown up between the failed pick and the push
+	notifyBackendAvailable()
+
+	start := time.Now()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case b := <-qr.ch:
+		waited := time.Since(start)
+		metrics.Inc("lb_backend_queue_released_total")
+		metrics.Add("lb_backend_queue_wait_seconds_total", waited.Seconds())
+		return b, waited, nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-r.Context().Done():
+		err = r.Context().Err()
+	}
+
+	backendQueueMu.Lock()
+	if qr.idx >= 0 {
+		heap.Remove(&backendQueue, qr.idx)
+		backendQueueMu.Unlock()
+		waited := time.Since(start)
+		metrics.Inc("lb_backend_queue_rejected_total", "reason", "timeout")
+		metrics.Add("lb_backend_queue_wait_seconds_total", waited.Seconds())
+		return nil, waited, err
+	}
+	backendQueueMu.Unlock()
+
+	// the dispatcher handed us a backend while we were giving up, use it
+	b := <-qr.ch
+	waited := time.Since(start)
+	metrics.Inc("lb_backend_queue_released_total")
+	metrics.Add("lb_backend_queue_wait_seconds_total", waited.Seconds())
+	return b, waited, nil
+}
@@ -0,0 +1,213 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+)
+
+const (
+	// limiterTolerance is how much the short RTT may exceed the long one before the limit shrinks
+	limiterTolerance = 1.5
+	// limiterSmoothing weights every new limit estimate against the current one
+	limiterSmoothing = 0.2
+	// limiterLongWindow is the number of samples the long (baseline) RTT averages over
+	limiterLongWindow = 600
+	// limiterShortWindow is the number of samples the short (current) RTT averages over
+	limiterShortWindow = 10
+	// limiterBackoff shrinks the limit when the pool answers with an overload error
+	limiterBackoff = 0.9
+)
+
+var (
+	errLimiterQueueFull = errors.New("concurrency queue full")
+	errLimiterTimeout   = errors.New("timed out waiting for a free slot")
+
+	limiter = &concurrencyLimiter{limit: config.ConcurrencyInitialLimit}
+)
+
+// concurrencyLimiter is a gradient based adaptive limiter (in the spirit of Netflix's Gradient2):
+// it compares the current request latency against a long term baseline and shrinks
+// the in-flight limit when the latency grows, grows it back when the latency is flat.
+// Requests beyond the limit wait in a FIFO queue.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	waiters  list.List // of chan struct{}, closed when the slot is handed over
+	longRTT  float64   // baseline latency, ns
+	shortRTT float64   // recent latency, ns
+}
+
+func init() {
+	metrics.GaugeFunc("lb_concurrency_limit", "Adaptive in-flight limit of the front door.", func() float64 {
+		limit, _, _ := ConcurrencyState()
+		return float64(limit)
+	})
+	metrics.GaugeFunc("lb_concurrency_inflight", "Requests currently in flight through the limiter.", func() float64 {
+		_, inflight, _ := ConcurrencyState()
+		return float64(inflight)
+	})
+	metrics.GaugeFunc("lb_concurrency_queue_depth", "Requests waiting for an in-flight slot.", func() float64 {
+		_, _, queued := ConcurrencyState()
+		return float64(queued)
+	})
+	metrics.Describe("lb_concurrency_rejected_total", metrics.Counter, "Requests rejected by the concurrency limiter.")
+}
+
+// limiterSampleKey is the context key of the limiterSample of a request.
+type limiterSampleKey struct{}
+
+// limiterSample is what a request tells the limiter once done: the round trip to its
+// backend, left unset when it never made one worth measuring.
+type limiterSample struct {
+	rtt        time.Duration
+	overloaded bool
+}
+
+// reportUpstreamRTT records the round trip of a request to its backend and the status
+// it answered for the limiter. Only ProxyHandler calls it, for the requests that went
+// straight to a backend: the time spent queued for one or cold starting it says nothing
+// about how loaded the pool is.
+func reportUpstreamRTT(r *http.Request, rtt time.Duration, status int) {
+	s, ok := r.Context().Value(limiterSampleKey{}).(*limiterSample)
+	if !ok {
+		return
+	}
+	s.rtt = rtt
+	s.overloaded = status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
+
+// ConcurrencyState returns the current in-flight limit, the requests in flight and
+// the requests queued, it's the saturation signal used by the scalers.
+func ConcurrencyState() (limit, inflight, queued int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return int(limiter.limit), limiter.inflight, limiter.waiters.Len()
+}
+
+// acquire takes an in-flight slot, waiting in the queue up to ConcurrencyQueueTimeout.
+func (l *concurrencyLimiter) acquire(r *http.Request) error {
+	l.mu.Lock()
+	if l.inflight < int(l.limit) && l.waiters.Len() == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiters.Len() >= config.ConcurrencyMaxQueue {
+		l.mu.Unlock()
+		return errLimiterQueueFull
+	}
+	ch := make(chan struct{})
+	elem := l.waiters.PushBack(ch)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(config.ConcurrencyQueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		err = errLimiterTimeout
+	case <-r.Context().Done():
+		err = r.Context().Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ch:
+		// the slot was handed over while we were giving up, keep it
+		return nil
+	default:
+		l.waiters.Remove(elem)
+		return err
+	}
+}
+
+// release gives the slot back, feeding the request latency to the limit estimation.
+// overloaded is set when the pool answered with an overload status, the latency is then ignored.
+func (l *concurrencyLimiter) release(rtt time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+
+	if overloaded {
+		l.limit = math.Max(config.ConcurrencyMinLimit, l.limit*limiterBackoff)
+	} else if rtt > 0 {
+		l.update(float64(rtt), inflight)
+	}
+
+	// hand the freed slots over to the waiters, in arrival order
+	for l.waiters.Len() > 0 && l.inflight < int(l.limit) {
+		ch := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inflight++
+		close(ch)
+	}
+}
+
+// update applies one latency sample to the limit. l.mu must be held.
+func (l *concurrencyLimiter) update(rtt float64, inflight int) {
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = rtt, rtt
+		return
+	}
+	l.shortRTT += (rtt - l.shortRTT) / limiterShortWindow
+	l.longRTT += (rtt - l.longRTT) / limiterLongWindow
+
+	// the latency dropped well below the baseline (e.g. after a scale up),
+	// let the baseline catch up faster
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// don't grow a limit the traffic doesn't even use
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1.0, limiterTolerance*l.longRTT/l.shortRTT))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-limiterSmoothing) + newLimit*limiterSmoothing
+	l.limit = math.Max(config.ConcurrencyMinLimit, math.Min(config.ConcurrencyMaxLimit, newLimit))
+}
+
+// ConcurrencyLimit wraps next with the adaptive limiter, requests over the limit are queued
+// and rejected with 503 once the queue is full or they waited too long. The limit moves
+// with the upstream round trips next reports through reportUpstreamRTT.
+func ConcurrencyLimit(next http.HandlerFunc) http.HandlerFunc {
+	if !config.ConcurrencyLimiterEnabled {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := limiter.acquire(r); err != nil {
+			if r.Context().Err() != nil {
+				// the client is gone, nobody to answer to
+				return
+			}
+			metrics.Inc("lb_concurrency_rejected_total", "reason", err.Error())
+			log.Printf("concurrency limiter rejected %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		sample := &limiterSample{}
+		next(w, r.WithContext(context.WithValue(r.Context(), limiterSampleKey{}, sample)))
+		limiter.release(sample.rtt, sample.overloaded)
+	}
+}
@@ -0,0 +1,148 @@
+package functions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+)
+
+// feed releases n requests that took rtt, the limiter being busy (half its limit in flight
+// at least) or not.
+func feed(l *concurrencyLimiter, rtt time.Duration, n int, busy bool) {
+	for range n {
+		l.inflight = 1
+		if busy {
+			l.inflight = int(l.limit)
+		}
+		l.release(rtt, false)
+	}
+}
+
+func TestConcurrencyLimiterMoves(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(l *concurrencyLimiter)
+		// check gets the limit before and after run
+		check func(before, after float64) bool
+		want  string
+	}{
+		{
+			name:  "flat latency grows the limit",
+			run:   func(l *concurrencyLimiter) { feed(l, 10*time.Millisecond, 50, true) },
+			check: func(before, after float64) bool { return after > before },
+			want:  "grown",
+		},
+		{
+			name:  "idle capacity isn't grown",
+			run:   func(l *concurrencyLimiter) { feed(l, 10*time.Millisecond, 50, false) },
+			check: func(before, after float64) bool { return after == before },
+			want:  "unchanged",
+		},
+		{
+			name: "growing latency shrinks the limit",
+			run: func(l *concurrencyLimiter) {
+				feed(l, 10*time.Millisecond, 50, true)
+				l.limit = config.ConcurrencyInitialLimit
+				feed(l, 100*time.Millisecond, 20, true)
+			},
+			check: func(before, after float64) bool { return after < before && after >= config.ConcurrencyMinLimit },
+			want:  "shrunk, not below the minimum",
+		},
+		{
+			name: "an overload backs off",
+			run: func(l *concurrencyLimiter) {
+				l.inflight = 1
+				l.release(0, true)
+			},
+			check: func(before, after float64) bool { return after == before*limiterBackoff },
+			want:  "backed off",
+		},
+		{
+			name: "an unmeasured request leaves it alone",
+			run: func(l *concurrencyLimiter) {
+				l.inflight = int(l.limit)
+				l.release(0, false)
+			},
+			check: func(before, after float64) bool { return after == before },
+			want:  "unchanged",
+		},
+		{
+			name: "it never goes below the minimum",
+			run: func(l *concurrencyLimiter) {
+				for range 100 {
+					l.inflight = 1
+					l.release(0, true)
+				}
+			},
+			check: func(before, after float64) bool { return after == config.ConcurrencyMinLimit },
+			want:  "at the minimum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &concurrencyLimiter{limit: config.ConcurrencyInitialLimit}
+			tt.run(l)
+			if after := l.limit; !tt.check(config.ConcurrencyInitialLimit, after) {
+				t.Errorf("limit %v -> %v, want it %s", float64(config.ConcurrencyInitialLimit), after, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitSamplesTheUpstreamOnly(t *testing.T) {
+	prev := limiter
+	limiter = &concurrencyLimiter{limit: config.ConcurrencyInitialLimit}
+	t.Cleanup(func() { limiter = prev })
+
+	useFakeRuntime(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/overloaded" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	ScaleUpN(1)
+	waitFor(t, 5*time.Second, "the replica to get ready", func() bool { return readyCount() == 1 })
+
+	h := ConcurrencyLimit(ProxyHandler())
+	do := func(path string) int {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	state := func() (float64, float64) {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.limit, limiter.longRTT
+	}
+
+	if code := do("/slow"); code != http.StatusOK {
+		t.Fatalf("proxied request: %d, want 200", code)
+	}
+	limit, rtt := state()
+	if rtt < float64(30*time.Millisecond) {
+		t.Fatalf("baseline rtt = %v, want the upstream round trip (30ms at least)", time.Duration(rtt))
+	}
+
+	// the fast fallback answers of a degraded pool aren't round trips
+	up := setDependencyDown(t, "limiter-test-db")
+	if code := do("/slow"); code != config.DegradedStatus {
+		t.Fatalf("degraded request: %d, want %d", code, config.DegradedStatus)
+	}
+	if l, r := state(); l != limit || r != rtt {
+		t.Errorf("degraded answer moved the limiter: limit %v -> %v, rtt %v -> %v", limit, l, time.Duration(rtt), time.Duration(r))
+	}
+	up()
+
+	if code := do("/overloaded"); code != http.StatusBadGateway {
+		t.Fatalf("overloaded request: %d, want 502", code)
+	}
+	if l, _ := state(); l != limit*limiterBackoff {
+		t.Errorf("limit after a 502 = %v, want %v", l, limit*limiterBackoff)
+	}
+}
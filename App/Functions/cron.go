@@ -0,0 +1,124 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronExpr is a parsed 5 field cron expression, each field is the set of matching values.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are set for a "*" day field, when both day fields are restricted
+	// a time matches if either does (the usual cron rule)
+	domAny, dowAny bool
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week", each field being "*",
+// a value, a range "a-b", a list "a,b" or any of them with a step "/n".
+// Sunday is 0 or 7 in the day-of-week field.
+func parseCron(expr string) (cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronExpr{}, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+	}
+
+	var c cronExpr
+	var err error
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.set, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return cronExpr{}, fmt.Errorf("cron %q: %w", expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField returns the bit set of the values matched by one field.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		i := strings.IndexByte(part, '/')
+		if i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var errA, errB error
+			lo, errA = strconv.Atoi(a)
+			hi, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", rng)
+			}
+			lo, hi = v, v
+			if i >= 0 {
+				// "5/15" means from 5 to the end
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the minute of t matches the expression, in the location of t.
+func (c cronExpr) matches(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domOK := c.dom&(1<<t.Day()) != 0
+	dowOK := c.dow&(1<<int(t.Weekday())) != 0
+	if !c.domAny && !c.dowAny {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// lastMatch returns the latest minute at or before t matching the expression,
+// looking back at most lookback. ok is false when there's none.
+func (c cronExpr) lastMatch(t time.Time, lookback time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for from := t.Add(-lookback); !t.Before(from); t = t.Add(-time.Minute) {
+		if c.matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,158 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCronRejects(t *testing.T) {
+	tests := []struct {
+		expr, err string
+	}{
+		{"* * * *", "want 5 fields, got 4"},
+		{"* * * * * *", "want 5 fields, got 6"},
+		{"60 * * * *", `"60" out of range 0-59`},
+		{"* 24 * * *", `"24" out of range 0-23`},
+		{"* * 0 * *", `"0" out of range 1-31`},
+		{"* * * 13 *", `"13" out of range 1-12`},
+		{"* * * * 8", `"8" out of range 0-7`},
+		{"* 10-5 * * *", `"10-5" out of range`},
+		{"*/0 * * * *", "bad step"},
+		{"*/x * * * *", "bad step"},
+		{"1-x * * * *", "bad range"},
+		{"mon * * * *", "bad value"},
+		{"1,,2 * * * *", "bad value"},
+	}
+	for _, tt := range tests {
+		if _, err := parseCron(tt.expr); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseCron(%q) error = %v, want one about %s", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestParseCronFields(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 59, []int{3}},
+		{"1-3", 0, 59, []int{1, 2, 3}},
+		{"1,5,9", 0, 59, []int{1, 5, 9}},
+		{"*/20", 0, 59, []int{0, 20, 40}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+		{"45/5", 0, 59, []int{45, 50, 55}},
+		{"1-2,22/1", 0, 23, []int{1, 2, 22, 23}},
+	}
+	for _, tt := range tests {
+		set, err := parseCronField(tt.field, tt.min, tt.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %v", tt.field, err)
+			continue
+		}
+		var want uint64
+		for _, v := range tt.want {
+			want |= 1 << v
+		}
+		if set != want {
+			t.Errorf("parseCronField(%q) = %b, want %b (%v)", tt.field, set, want, tt.want)
+		}
+	}
+}
+
+func TestCronLastMatch(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		now      time.Time
+		lookback time.Duration
+		want     time.Time // zero when there's no match
+	}{
+		{
+			name: "same minute", expr: "30 9 * * *",
+			now: at(time.UTC, "2026-03-10 09:30"), lookback: time.Hour,
+			want: at(time.UTC, "2026-03-10 09:30"),
+		},
+		{
+			name: "across midnight", expr: "0 22 * * *",
+			now: at(time.UTC, "2026-03-10 01:15"), lookback: 24 * time.Hour,
+			want: at(time.UTC, "2026-03-09 22:00"),
+		},
+		{
+			name: "out of the lookback", expr: "0 22 * * *",
+			now: at(time.UTC, "2026-03-10 01:15"), lookback: 2 * time.Hour,
+		},
+		{
+			name: "across a month boundary", expr: "0 8 31 * *",
+			now: at(time.UTC, "2026-04-02 12:00"), lookback: 7 * 24 * time.Hour,
+			want: at(time.UTC, "2026-03-31 08:00"),
+		},
+		{
+			name: "across a year boundary", expr: "59 23 * 12 *",
+			now: at(time.UTC, "2026-01-01 00:30"), lookback: 24 * time.Hour,
+			want: at(time.UTC, "2025-12-31 23:59"),
+		},
+		{
+			// 2026-03-06 is a friday, the weekdays only
+			name: "day of week range", expr: "0 9 * * 1-5",
+			now: at(time.UTC, "2026-03-09 08:00"), lookback: 7 * 24 * time.Hour,
+			want: at(time.UTC, "2026-03-06 09:00"),
+		},
+		{
+			name: "sunday as 7", expr: "0 12 * * 7",
+			now: at(time.UTC, "2026-03-10 00:00"), lookback: 7 * 24 * time.Hour,
+			want: at(time.UTC, "2026-03-08 12:00"),
+		},
+		{
+			// either day field matches when both are restricted: the 1st or a monday
+			name: "day of month or day of week", expr: "0 0 1 * 1",
+			now: at(time.UTC, "2026-03-05 00:00"), lookback: 7 * 24 * time.Hour,
+			want: at(time.UTC, "2026-03-02 00:00"),
+		},
+		{
+			name: "in the location of now", expr: "0 9 * * *",
+			now: at(ny, "2026-03-10 10:00"), lookback: 2 * time.Hour,
+			want: at(ny, "2026-03-10 09:00"),
+		},
+		{
+			// 02:30 doesn't exist on 2026-03-08 in New York, the clocks jump from 02:00 to 03:00
+			name: "skipped by the spring DST change", expr: "30 2 * * *",
+			now: at(ny, "2026-03-08 03:30"), lookback: 48 * time.Hour,
+			want: at(ny, "2026-03-07 02:30"),
+		},
+		{
+			// 01:30 happens twice on 2026-11-01 in New York, the last one wins
+			name: "repeated by the fall DST change", expr: "30 1 * * *",
+			now: time.Date(2026, 11, 1, 6, 45, 0, 0, time.UTC).In(ny), lookback: 24 * time.Hour,
+			want: time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := c.lastMatch(tt.now, tt.lookback)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("lastMatch(%v) = %v, %v, want %v", tt.now, got, ok, tt.want)
+			}
+		})
+	}
+}
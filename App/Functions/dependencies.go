@@ -0,0 +1,253 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// dependencyState is the runtime state of one monitored dependency.
+type dependencyState struct {
+	Name        string    `json:"name"`
+	Up          bool      `json:"up"`
+	Fails       int       `json:"consecutive_fails"`
+	DownSince   time.Time `json:"down_since,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastRestart time.Time `json:"last_restart,omitempty"`
+}
+
+var (
+	// dependenciesMu guards dependencyStates
+	dependenciesMu   sync.Mutex
+	dependencyStates = map[string]*dependencyState{}
+)
+
+func init() {
+	metrics.Describe("lb_dependency_up", metrics.Gauge, "1 when the dependency answers its probe.")
+	metrics.Describe("lb_dependency_restarts_total", metrics.Counter, "Restarts of a dependency container.")
+	metrics.GaugeFunc("lb_pool_dependency_degraded", "1 while the pool is degraded because a dependency is down.", func() float64 {
+		if DependencyDegraded() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// DependencyDegraded reports whether one of the declared dependencies is down. While it is,
+// the replicas are neither killed (unless they're gone for real) nor scaled and the clients
+// get the fallback response.
+func DependencyDegraded() bool {
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+	for _, st := range dependencyStates {
+		if !st.Up {
+			return true
+		}
+	}
+	return false
+}
+
+// StartDependencyMonitor probes every declared dependency on its own interval.
+func StartDependencyMonitor() {
+	for _, dep := range config.Dependencies {
+		dependenciesMu.Lock()
+		// assumed up until proven otherwise, so the start isn't degraded
+		dependencyStates[dep.Name] = &dependencyState{Name: dep.Name, Up: true}
+		dependenciesMu.Unlock()
+		metrics.Set("lb_dependency_up", 1, "dependency", dep.Name)
+
+		go monitorDependency(dep)
+	}
+}
+
+func monitorDependency(dep structers.Dependency) {
+	ticker := time.NewTicker(dep.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := probeDependency(dep)
+		restart := recordDependencyProbe(dep, err)
+		if restart {
+			if err := RestartDependency(dep.Name); err != nil {
+				log.Printf("dependency %s: restart failed: %v", dep.Name, err)
+			}
+		}
+	}
+}
+
+// recordDependencyProbe updates the dependency state with one probe result and tells
+// whether its container is due for an automatic restart.
+func recordDependencyProbe(dep structers.Dependency, err error) bool {
+	dependenciesMu.Lock()
+	st := dependencyStates[dep.Name]
+	wasUp := st.Up
+
+	if err == nil {
+		st.Up, st.Fails, st.DownSince, st.LastError = true, 0, time.Time{}, ""
+	} else {
+		st.Fails++
+		st.LastError = err.Error()
+		if st.Fails >= dep.FailureThreshold && st.Up {
+			st.Up = false
+			st.DownSince = time.Now()
+		}
+	}
+
+	restart := !st.Up && dep.RestartAfter > 0 && dep.ContainerName != "" &&
+		time.Since(st.DownSince) >= dep.RestartAfter &&
+		time.Since(st.LastRestart) >= dep.RestartCooldown
+	isUp := st.Up
+	dependenciesMu.Unlock()
+
+	switch {
+	case wasUp && !isUp:
+		metrics.Set("lb_dependency_up", 0, "dependency", dep.Name)
+		emitEvent(EventDependencyDown, config.ParentName,
+			fmt.Sprintf("%s down (%v), pool degraded: no kill, no scaling, fallback served", dep.Name, err), "")
+	case !wasUp && isUp:
+		metrics.Set("lb_dependency_up", 1, "dependency", dep.Name)
+		emitEvent(EventDependencyUp, config.ParentName, dep.Name+" back up", "")
+	}
+	return restart
+}
+
+// probeDependency checks the dependency with the probe of its Kind.
+func probeDependency(dep structers.Dependency) error {
+	conn, err := net.DialTimeout("tcp", dep.Address, dep.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if dep.Kind != "postgres" {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(dep.Timeout))
+	return postgresHandshake(conn, dep.User, dep.Database)
+}
+
+// postgresHandshake sends a protocol 3.0 startup message and expects the server to answer
+// with an authentication request, which it only does once it accepts connections
+// (a starting or shutting down server answers with an error).
+func postgresHandshake(conn net.Conn, user, database string) error {
+	var params bytes.Buffer
+	binary.Write(&params, binary.BigEndian, int32(196608)) // protocol 3.0
+	for _, kv := range []string{"user", user, "database", database} {
+		params.WriteString(kv)
+		params.WriteByte(0)
+	}
+	params.WriteByte(0)
+
+	msg := make([]byte, 4, 4+params.Len())
+	binary.BigEndian.PutUint32(msg, uint32(4+params.Len()))
+	msg = append(msg, params.Bytes()...)
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("postgres startup: %w", err)
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("postgres startup reply: %w", err)
+	}
+
+	// terminate politely, we never authenticate
+	defer conn.Write([]byte{'X', 0, 0, 0, 4})
+
+	switch header[0] {
+	case 'R':
+		return nil
+	case 'E':
+		size := int(binary.BigEndian.Uint32(header[1:])) - 4
+		body := make([]byte, max(0, min(size, 1024)))
+		io.ReadFull(conn, body)
+		return fmt.Errorf("postgres refused: %s", postgresErrorMessage(body))
+	default:
+		return fmt.Errorf("postgres startup: unexpected message %q", header[0])
+	}
+}
+
+// postgresErrorMessage extracts the human readable message ('M' field) of an ErrorResponse body.
+func postgresErrorMessage(body []byte) string {
+	for len(body) > 1 && body[0] != 0 {
+		field := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			break
+		}
+		if field == 'M' {
+			return string(body[1 : 1+end])
+		}
+		body = body[end+2:]
+	}
+	return "unknown error"
+}
+
+// RestartDependency restarts the container of the named dependency.
+func RestartDependency(name string) error {
+	var dep *structers.Dependency
+	for i := range config.Dependencies {
+		if config.Dependencies[i].Name == name {
+			dep = &config.Dependencies[i]
+		}
+	}
+	if dep == nil {
+		return fmt.Errorf("unknown dependency %q", name)
+	}
+	if dep.ContainerName == "" {
+		return fmt.Errorf("dependency %q has no container", name)
+	}
+
+	dependenciesMu.Lock()
+	if st, ok := dependencyStates[name]; ok {
+		st.LastRestart = time.Now()
+	}
+	dependenciesMu.Unlock()
+
+	ctx := context.Background()
+	if err := replicaRuntime.Stop(ctx, dep.ContainerName); err != nil {
+		return fmt.Errorf("restart %s: stop: %w", dep.ContainerName, err)
+	}
+	if err := replicaRuntime.Start(ctx, dep.ContainerName); err != nil {
+		return fmt.Errorf("restart %s: start: %w", dep.ContainerName, err)
+	}
+
+	metrics.Inc("lb_dependency_restarts_total", "dependency", name)
+	emitEvent(EventDependencyRestarted, config.ParentName, name+" container restarted", dep.ContainerName)
+	return nil
+}
+
+// dependencyStatus returns a copy of the state of every dependency.
+func dependencyStatus() []dependencyState {
+	dependenciesMu.Lock()
+	defer dependenciesMu.Unlock()
+
+	out := make([]dependencyState, 0, len(dependencyStates))
+	for _, dep := range config.Dependencies {
+		if st, ok := dependencyStates[dep.Name]; ok {
+			out = append(out, *st)
+		}
+	}
+	return out
+}
+
+// serveDegraded answers with the configured fallback response.
+func serveDegraded(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", config.DegradedContentType)
+	w.Header().Set("Retry-After", strconv.Itoa(config.DegradedRetryAfter))
+	w.WriteHeader(config.DegradedStatus)
+	io.WriteString(w, config.DegradedBody)
+}
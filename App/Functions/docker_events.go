@@ -0,0 +1,180 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+func init() {
+	metrics.Describe("lb_docker_events_total", metrics.Counter, "Docker events received for the pool replicas, by action.")
+	metrics.Describe("lb_docker_events_reconnects_total", metrics.Counter, "Reconnections to the Docker events stream.")
+}
+
+// WatchDockerEvents follows the Docker events of the pool containers and reacts right away
+// to a replica dying instead of waiting for the next probe. When the stream drops it
+// reconnects with a backoff and resyncs the backends against the running containers.
+func WatchDockerEvents() {
+	backoff := config.DockerEventsReconnectMin
+
+	for {
+		start := time.Now()
+		err := followDockerEvents()
+		log.Printf("docker events stream dropped: %v", err)
+		metrics.Inc("lb_docker_events_reconnects_total")
+
+		// a stream that lived for a while gets a fresh backoff
+		if time.Since(start) > config.DockerEventsReconnectMax {
+			backoff = config.DockerEventsReconnectMin
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, config.DockerEventsReconnectMax)
+	}
+}
+
+// followDockerEvents subscribes to the events stream of the runtime, resyncs, and handles
+// the events until the stream fails.
+func followDockerEvents() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs, errs := replicaRuntime.Events(ctx, map[string]string{"com.docker.compose.service": config.ParentName})
+
+	// we may have missed events while disconnected, catch up with the current state
+	if err := resyncContainers(ctx); err != nil {
+		log.Printf("docker events resync: %v", err)
+	}
+
+	for {
+		select {
+		case msg := <-msgs:
+			handleDockerEvent(msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// handleDockerEvent applies one container event to the matching backend.
+func handleDockerEvent(msg structers.ContainerEvent) {
+	action := msg.Action
+	metrics.Inc("lb_docker_events_total", "action", strings.SplitN(action, ":", 2)[0])
+
+	b := findBackend(msg.ID)
+	if b == nil {
+		// not one of ours (anymore)
+		return
+	}
+
+	switch {
+	case action == ActionDie, action == ActionOOM, action == ActionKill, action == ActionDestroy:
+		markContainerGone(b, action)
+
+	case action == ActionHealthUnhealthy:
+		config.BackendsMu.Lock()
+		if b.Alive && !b.Ill && atomic.LoadInt32(&b.ShuttingDown) == 0 {
+			b.Ill = true
+			log.Printf("Backend %s marked as ill (docker reports unhealthy)", b.URL.String())
+			if inHeap(b) {
+				heap.Fix(&config.Backends, b.HeapIdx)
+			}
+			appendIfNewUnhealthy(b)
+			noteTransition(b, SourcePassive, probeResult{err: errors.New(action)})
+		}
+		config.BackendsMu.Unlock()
+		probeNow(b)
+
+	case strings.HasPrefix(action, ActionHealthStatus):
+		// healthy or free-form status, let our own probe decide
+		probeNow(b)
+	}
+}
+
+// markContainerGone takes a backend whose container stopped out of rotation
+// and hands it to the remediation loop.
+func markContainerGone(b *structers.Backend, reason string) {
+	config.BackendsMu.Lock()
+	// stopped by us (scale down, replacement), nothing to react to
+	if atomic.LoadInt32(&b.ShuttingDown) == 1 || b.Exited {
+		config.BackendsMu.Unlock()
+		return
+	}
+	b.Alive = false
+	b.Ill = false
+	b.Exited = true
+	if b.DeadSince.IsZero() {
+		b.DeadSince = time.Now()
+		scheduleDeadProbe(b)
+	}
+	if inHeap(b) {
+		heap.Remove(&config.Backends, b.HeapIdx)
+	}
+	appendIfNewUnhealthy(b)
+	noteTransition(b, SourcePassive, probeResult{err: errors.New("container " + reason)})
+	config.BackendsMu.Unlock()
+
+	emitEvent(EventReplicaExited, b.Pool, "container "+reason, b.ContainerID)
+	triggerRemediation()
+}
+
+// resyncContainers marks dead every backend whose container isn't running anymore.
+func resyncContainers(ctx context.Context) error {
+	list, err := replicaRuntime.List(ctx, map[string]string{"com.docker.compose.service": config.ParentName}, true)
+	if err != nil {
+		return fmt.Errorf("list containers: %w", err)
+	}
+
+	running := make(map[string]bool, len(list))
+	for _, c := range list {
+		running[c.ID] = c.State == ContainerRunning
+	}
+
+	config.BackendsMu.Lock()
+	all := append(append([]*structers.Backend(nil), config.Backends...), config.Unhealthy...)
+	config.BackendsMu.Unlock()
+
+	for _, b := range all {
+		if !running[b.ContainerID] {
+			markContainerGone(b, "not running on resync")
+		}
+	}
+	return nil
+}
+
+// findBackend returns the backend running the given container, nil when unknown.
+func findBackend(containerID string) *structers.Backend {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	for _, b := range config.Backends {
+		if b.ContainerID == containerID {
+			return b
+		}
+	}
+	for _, b := range config.Unhealthy {
+		if b.ContainerID == containerID {
+			return b
+		}
+	}
+	return nil
+}
+
+// probeNow asks the health checker for an immediate probe of b (non-blocking).
+func probeNow(b *structers.Backend) {
+	select {
+	case config.NewBackendTrigger <- b:
+	default:
+		// buffer full, the next sweep will get it
+	}
+}
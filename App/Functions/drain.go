@@ -0,0 +1,121 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// Scale down victim policies
+const (
+	VictimNewest       = "newest"
+	VictimOldest       = "oldest"
+	VictimLeastLoaded  = "least_loaded"
+	VictimHighestUsage = "highest_usage"
+)
+
+var (
+	// drainingReplicas counts the replicas waiting for their in-flight requests
+	drainingReplicas int32
+)
+
+func init() {
+	metrics.GaugeFunc("lb_draining_replicas", "Replicas out of rotation waiting for their in-flight requests.", func() float64 {
+		return float64(atomic.LoadInt32(&drainingReplicas))
+	})
+	metrics.Describe("lb_drains_total", metrics.Counter, "Replica drains, by outcome (clean or timeout).")
+	metrics.Describe("lb_drain_seconds_total", metrics.Counter, "Total time spent draining replicas.")
+}
+
+// victimCandidates returns the replicas in rotation old enough to be removed, the first to
+// remove first according to config.ScaleDownVictimPolicy.
+func victimCandidates() []*structers.Backend {
+	config.BackendsMu.Lock()
+	var out []*structers.Backend
+	for _, b := range config.Backends {
+		if b.Alive && atomic.LoadInt32(&b.ShuttingDown) == 0 && time.Since(b.StartTime) >= config.MinReplicaLifetime {
+			out = append(out, b)
+		}
+	}
+	config.BackendsMu.Unlock()
+
+	switch config.ScaleDownVictimPolicy {
+	case VictimNewest:
+		slices.SortStableFunc(out, func(a, b *structers.Backend) int { return b.StartTime.Compare(a.StartTime) })
+	case VictimOldest:
+		slices.SortStableFunc(out, func(a, b *structers.Backend) int { return a.StartTime.Compare(b.StartTime) })
+	case VictimHighestUsage:
+		sortByUsage(out)
+	default:
+		slices.SortStableFunc(out, func(a, b *structers.Backend) int {
+			return int(atomic.LoadInt64(&a.CurrentLoad) - atomic.LoadInt64(&b.CurrentLoad))
+		})
+	}
+	return out
+}
+
+// sortByUsage orders the backends by CPU usage, then memory usage, the highest first.
+// The ones whose stats can't be read go last.
+func sortByUsage(backends []*structers.Backend) {
+	ids := make([]string, len(backends))
+	for i, b := range backends {
+		ids[i] = b.ContainerID
+	}
+	results := make([]stats, len(ids))
+	appendResults(ids, &results)
+
+	usage := make(map[*structers.Backend]stats, len(backends))
+	for i, b := range backends {
+		usage[b] = results[i]
+		if results[i].err != nil {
+			usage[b] = stats{cpuPct: -1, memPct: -1}
+		}
+	}
+	slices.SortStableFunc(backends, func(a, b *structers.Backend) int {
+		ua, ub := usage[a], usage[b]
+		if ua.cpuPct != ub.cpuPct {
+			return cmpDesc(ua.cpuPct, ub.cpuPct)
+		}
+		return cmpDesc(ua.memPct, ub.memPct)
+	})
+}
+
+func cmpDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
+
+// drainBackend waits for the in-flight requests of a backend taken out of rotation to
+// finish, at most DrainTimeout, past which the remaining ones are cut by the stop.
+func drainBackend(b *structers.Backend) {
+	atomic.AddInt32(&drainingReplicas, 1)
+	defer atomic.AddInt32(&drainingReplicas, -1)
+
+	start := time.Now()
+	deadline := start.Add(config.DrainTimeout)
+	for atomic.LoadInt64(&b.CurrentLoad) > 0 && time.Now().Before(deadline) {
+		time.Sleep(config.DrainPollInterval)
+	}
+
+	took := time.Since(start)
+	metrics.Add("lb_drain_seconds_total", took.Seconds())
+	if left := atomic.LoadInt64(&b.CurrentLoad); left > 0 {
+		metrics.Inc("lb_drains_total", "outcome", "timeout")
+		log.Printf("drain of %s timed out after %v, stopping it with %d requests in flight", b.ContainerID, config.DrainTimeout, left)
+		return
+	}
+	metrics.Inc("lb_drains_total", "outcome", "clean")
+	log.Printf("drained %s in %v", b.ContainerID, took.Round(time.Millisecond))
+}
@@ -0,0 +1,145 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// dryRunMu guards dryRunPools
+	dryRunMu sync.Mutex
+
+	// dryRunPools holds the pools whose scaling decisions are only logged, seeded from config.DryRun
+	dryRunPools = map[string]bool{}
+
+	// dryRunReplaced holds the dead replicas a dry-run replacement was already reported for
+	dryRunReplaced = map[string]bool{}
+
+	// dryRunActivatedAt is when a dry-run activation from zero was last reported (unix nanos)
+	dryRunActivatedAt int64
+)
+
+func init() {
+	for pool, on := range config.DryRun {
+		dryRunPools[pool] = on
+	}
+	metrics.Describe("lb_scaling_dry_run", metrics.Gauge, "1 while the scaling of the pool runs in dry-run mode.")
+	metrics.Describe("lb_scaling_dry_run_decisions_total", metrics.Counter, "Scale operations a dry-run pool would have done, by action.")
+	metrics.Describe("lb_scaling_dry_run_desired_replicas", metrics.Gauge, "Replica count a dry-run pool would have scaled to.")
+	metrics.Set("lb_scaling_dry_run", boolGauge(DryRunEnabled(config.ParentName)), "pool", config.ParentName)
+}
+
+// DryRunEnabled reports whether the scaling of the pool only logs what it would do.
+func DryRunEnabled(pool string) bool {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	return dryRunPools[pool]
+}
+
+// SetDryRun switches the dry-run mode of the pool, only the pool this balancer scales is known.
+func SetDryRun(pool string, on bool) error {
+	if pool != config.ParentName {
+		return fmt.Errorf("unknown pool %q", pool)
+	}
+
+	dryRunMu.Lock()
+	was := dryRunPools[pool]
+	dryRunPools[pool] = on
+	if !on {
+		dryRunReplaced = map[string]bool{}
+	}
+	dryRunMu.Unlock()
+
+	if was != on {
+		log.Printf("scaling of pool %s: dry-run %v", pool, on)
+	}
+	metrics.Set("lb_scaling_dry_run", boolGauge(on), "pool", pool)
+	return nil
+}
+
+// dryRunStatus returns the dry-run mode of every known pool.
+func dryRunStatus() map[string]bool {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	out := map[string]bool{config.ParentName: false}
+	for pool, on := range dryRunPools {
+		out[pool] = on
+	}
+	return out
+}
+
+// logDryRun reports the scale operation a dry-run decision would have done.
+func logDryRun(d structers.ScalingDecision) {
+	verb := "ScaleUp"
+	if d.Action == ScaleActionDown {
+		verb = "ScaleDown"
+	}
+	log.Printf("dry-run pool=%s: would %s: %s (%d -> %d, %s won)", d.Pool, verb, d.Reason, d.Current, d.Desired, d.Winner)
+	metrics.Inc("lb_scaling_dry_run_decisions_total", "pool", d.Pool, "action", d.Action)
+	metrics.Set("lb_scaling_dry_run_desired_replicas", float64(d.Desired), "pool", d.Pool)
+}
+
+// emitDryRunEvent publishes the event an operation skipped in dry-run would have emitted.
+func emitDryRunEvent(action string, ev structers.Event) {
+	ev.DryRun = true
+	log.Printf("dry-run pool=%s: would %s: %s", ev.Pool, action, ev.Reason)
+	metrics.Inc("lb_scaling_dry_run_decisions_total", "pool", ev.Pool, "action", action)
+	publishEvent(ev)
+}
+
+// dryRunActivation reports the activation from zero a dry-run pool would have done,
+// at most once per ActivationTimeout as every held request asks for one.
+func dryRunActivation() {
+	now := time.Now()
+	last := atomic.LoadInt64(&dryRunActivatedAt)
+	if now.Sub(time.Unix(0, last)) < config.ActivationTimeout ||
+		!atomic.CompareAndSwapInt64(&dryRunActivatedAt, last, now.UnixNano()) {
+		return
+	}
+	emitDryRunEvent("activate", structers.Event{
+		Type:   EventScaleUp,
+		Pool:   config.ParentName,
+		Reason: "cold start: pool is scaled to zero",
+		Before: 0,
+		After:  1,
+	})
+}
+
+// dryRunReplacement reports the replacement of a dead replica a dry-run pool would have done,
+// once per replica as the remediation finds it again on every pass.
+func dryRunReplacement(b *structers.Backend) {
+	dryRunMu.Lock()
+	reported := dryRunReplaced[b.ContainerID]
+	dryRunReplaced[b.ContainerID] = true
+	dryRunMu.Unlock()
+	if reported {
+		return
+	}
+
+	n := replicaCount()
+	emitDryRunEvent("replace", structers.Event{
+		Type:        EventReplicaReplaced,
+		Pool:        b.Pool,
+		Reason:      "dead replica left in place",
+		ContainerID: b.ContainerID,
+		Before:      n,
+		After:       n,
+	})
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package functions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// useDryRun puts the pool in dry-run mode with an empty event list until the end of the test.
+func useDryRun(t *testing.T) {
+	t.Helper()
+	clearEvents := func() {
+		eventsMu.Lock()
+		events = nil
+		eventsMu.Unlock()
+	}
+	clearEvents()
+	if err := SetDryRun(config.ParentName, true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetDryRun(config.ParentName, false)
+		atomic.StoreInt64(&dryRunActivatedAt, 0)
+		clearEvents()
+	})
+}
+
+// dryRunEvents returns the kept dry-run events of the given type.
+func dryRunEvents(eventType string) []structers.Event {
+	var out []structers.Event
+	for _, ev := range recentEvents() {
+		if ev.DryRun && ev.Type == eventType {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestSetDryRunRejectsUnknownPools(t *testing.T) {
+	if err := SetDryRun("other", true); err == nil {
+		t.Error("SetDryRun(other) succeeded, want an error")
+	}
+	if DryRunEnabled("other") {
+		t.Error("unknown pool switched to dry-run")
+	}
+
+	admin := AdminHandler()
+	for pool, want := range map[string]int{"other": http.StatusNotFound, config.ParentName: http.StatusOK} {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/dryrun/"+pool+"?enabled=false", nil))
+		if rec.Code != want {
+			t.Errorf("POST /admin/dryrun/%s = %d, want %d", pool, rec.Code, want)
+		}
+	}
+}
+
+func TestDryRunActivationFromZero(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+	useDryRun(t)
+
+	// every held request asks for an activation
+	activateFromZero()
+	activateFromZero()
+
+	if names := containerNames(t, rt); len(names) != 0 {
+		t.Errorf("containers = %v, want none in dry-run", names)
+	}
+	if atomic.LoadInt32(&activating) != 0 {
+		t.Error("activation started in dry-run")
+	}
+	if evs := dryRunEvents(EventScaleUp); len(evs) != 1 || evs[0].Before != 0 || evs[0].After != 1 {
+		t.Errorf("dry-run scale up events = %+v, want one 0 -> 1", evs)
+	}
+}
+
+func TestDryRunReplacement(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+
+	res := ScaleUpN(1)
+	if len(res.Succeeded) != 1 {
+		t.Fatalf("ScaleUpN(1) = %+v", res)
+	}
+	old := res.Succeeded[0]
+	waitFor(t, 5*time.Second, "the replica to get ready", func() bool { return readyCount() == 1 })
+	b := findBackend(old)
+
+	useDryRun(t)
+	// found again on every remediation pass
+	replaceReplica(b)
+	replaceReplica(b)
+
+	if names := containerNames(t, rt); len(names) != 1 {
+		t.Errorf("containers = %v, want only the old one", names)
+	}
+	if _, err := rt.Inspect(context.Background(), old); err != nil {
+		t.Errorf("old container removed in dry-run: %v", err)
+	}
+	if findBackend(old) == nil {
+		t.Error("old backend unfiled in dry-run")
+	}
+	if evs := dryRunEvents(EventReplicaReplaced); len(evs) != 1 || evs[0].ContainerID != old {
+		t.Errorf("dry-run replaced events = %+v, want one for %s", evs, old)
+	}
+}
+
+func TestDryRunStartupRetry(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+
+	res := ScaleUpN(1)
+	if len(res.Succeeded) != 1 {
+		t.Fatalf("ScaleUpN(1) = %+v", res)
+	}
+	waitFor(t, 5*time.Second, "the replica to get ready", func() bool { return readyCount() == 1 })
+
+	useDryRun(t)
+	startup := structers.StartupProbeSpec{Timeout: time.Second, Retries: 1, Backoff: time.Millisecond}
+	failStartup(findBackend(res.Succeeded[0]), probeResult{}, startup, 0)
+
+	// the failed replica is still torn down, only the retry is skipped
+	if names := containerNames(t, rt); len(names) != 0 {
+		t.Errorf("containers = %v, want none after the failed startup", names)
+	}
+	if evs := dryRunEvents(EventReplicaCreated); len(evs) != 1 {
+		t.Errorf("dry-run created events = %+v, want one for the retry", evs)
+	}
+}
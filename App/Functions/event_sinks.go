@@ -0,0 +1,231 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// eventLogMu guards eventLogFile and eventLogSize
+	eventLogMu   sync.Mutex
+	eventLogFile *os.File
+	eventLogSize int64
+
+	// webhookSinks pairs every config.EventWebhooks entry with the queue of its events
+	webhookSinks = newWebhookSinks(config.EventWebhooks)
+
+	webhookClient = &http.Client{Timeout: config.WebhookTimeout}
+)
+
+// webhookSink is a webhook and the events waiting for delivery to it.
+type webhookSink struct {
+	hook  structers.EventWebhook
+	queue chan structers.Event
+}
+
+func init() {
+	metrics.Describe("lb_event_log_errors_total", metrics.Counter, "Events that couldn't be appended to the event log.")
+	metrics.Describe("lb_webhook_deliveries_total", metrics.Counter, "Event deliveries to a webhook, by outcome (ok, failed, dropped).")
+	metrics.Describe("lb_webhook_attempts_total", metrics.Counter, "Delivery attempts to a webhook, retries included.")
+}
+
+// newWebhookSinks gives each webhook its queue of config.WebhookQueueSize events.
+func newWebhookSinks(hooks []structers.EventWebhook) []webhookSink {
+	sinks := make([]webhookSink, len(hooks))
+	for i, hook := range hooks {
+		sinks[i] = webhookSink{hook: hook, queue: make(chan structers.Event, config.WebhookQueueSize)}
+	}
+	return sinks
+}
+
+// writeEventLog appends the event as one JSON line to config.EventLogPath, rotating the
+// file first when it would grow past EventLogMaxSize.
+func writeEventLog(ev structers.Event) {
+	if config.EventLogPath == "" {
+		return
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("event log: encode: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	eventLogMu.Lock()
+	defer eventLogMu.Unlock()
+
+	if eventLogFile != nil && eventLogSize+int64(len(line)) > config.EventLogMaxSize {
+		eventLogFile.Close()
+		eventLogFile = nil
+		rotateEventLog()
+	}
+	if eventLogFile == nil {
+		if err := openEventLog(); err != nil {
+			metrics.Inc("lb_event_log_errors_total")
+			log.Printf("event log: %v", err)
+			return
+		}
+	}
+
+	n, err := eventLogFile.Write(line)
+	eventLogSize += int64(n)
+	if err != nil {
+		metrics.Inc("lb_event_log_errors_total")
+		log.Printf("event log: write: %v", err)
+	}
+}
+
+// openEventLog opens (or creates) the event log for appending. eventLogMu must be held.
+func openEventLog() error {
+	if err := os.MkdirAll(filepath.Dir(config.EventLogPath), 0o755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+	f, err := os.OpenFile(config.EventLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat: %w", err)
+	}
+	eventLogFile, eventLogSize = f, info.Size()
+	return nil
+}
+
+// rotateEventLog shifts events.jsonl.N to .N+1, dropping the oldest, and the current file
+// to .1. eventLogMu must be held.
+func rotateEventLog() {
+	path := config.EventLogPath
+	os.Remove(path + "." + strconv.Itoa(config.EventLogBackups))
+	for i := config.EventLogBackups - 1; i >= 1; i-- {
+		os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+	}
+	if config.EventLogBackups > 0 {
+		if err := os.Rename(path, path+".1"); err != nil {
+			log.Printf("event log: rotate: %v", err)
+		}
+	} else {
+		os.Remove(path)
+	}
+}
+
+// enqueueWebhooks queues the event for every webhook interested in its type,
+// dropping it for the webhooks whose queue is full.
+func enqueueWebhooks(ev structers.Event) {
+	for _, sink := range webhookSinks {
+		if len(sink.hook.Types) > 0 && !slices.Contains(sink.hook.Types, ev.Type) {
+			continue
+		}
+		select {
+		case sink.queue <- ev:
+		default:
+			metrics.Inc("lb_webhook_deliveries_total", "webhook", sink.hook.Name, "outcome", "dropped")
+			log.Printf("webhook %s: queue full, event %s dropped", sink.hook.Name, ev.Type)
+		}
+	}
+}
+
+// DeliverWebhooks sends the queued events to their webhook, one worker per webhook so a slow
+// one doesn't hold the others back. Meant to run in its own goroutine.
+func DeliverWebhooks() {
+	var wg sync.WaitGroup
+	for _, sink := range webhookSinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range sink.queue {
+				deliverEvent(sink.hook, ev)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverEvent POSTs the event to the webhook, retrying with a backoff on network
+// errors, 429 and 5xx answers.
+func deliverEvent(hook structers.EventWebhook, ev structers.Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("webhook %s: encode: %v", hook.Name, err)
+		return
+	}
+	secret := ""
+	if hook.SecretEnv != "" {
+		secret = os.Getenv(hook.SecretEnv)
+	}
+
+	backoff := config.WebhookBackoff
+	for attempt := 1; ; attempt++ {
+		metrics.Inc("lb_webhook_attempts_total", "webhook", hook.Name)
+		retry, err := postEvent(hook.URL, secret, ev.Type, body)
+		if err == nil {
+			metrics.Inc("lb_webhook_deliveries_total", "webhook", hook.Name, "outcome", "ok")
+			return
+		}
+		if !retry || attempt >= config.WebhookMaxAttempts {
+			metrics.Inc("lb_webhook_deliveries_total", "webhook", hook.Name, "outcome", "failed")
+			log.Printf("webhook %s: event %s not delivered after %d attempts: %v", hook.Name, ev.Type, attempt, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postEvent makes one delivery attempt and tells whether a failure is worth retrying.
+// A signed request carries X-LB-Timestamp and X-LB-Signature: "sha256=" followed by the
+// hex HMAC-SHA256 of "<timestamp>.<body>", so the receiver can check it and reject replays.
+func postEvent(url, secret, eventType string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-LB-Event", eventType)
+	if secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		req.Header.Set("X-LB-Timestamp", ts)
+		req.Header.Set("X-LB-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package functions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+func TestWebhooksGetTheirEventTypesSigned(t *testing.T) {
+	type delivery struct {
+		hook, eventType string
+		signed          bool
+	}
+	var (
+		mu  sync.Mutex
+		got []delivery
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ev structers.Event
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("body %q: %v", body, err)
+		}
+		if r.Header.Get("X-LB-Event") != ev.Type {
+			t.Errorf("X-LB-Event = %q, want %q", r.Header.Get("X-LB-Event"), ev.Type)
+		}
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(r.Header.Get("X-LB-Timestamp") + "."))
+		mac.Write(body)
+		signed := r.Header.Get("X-LB-Signature") == "sha256="+hex.EncodeToString(mac.Sum(nil))
+
+		mu.Lock()
+		got = append(got, delivery{r.URL.Path, ev.Type, signed})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	t.Setenv("LB_TEST_WEBHOOK_SECRET", "s3cret")
+	saved := webhookSinks
+	webhookSinks = newWebhookSinks([]structers.EventWebhook{
+		{Name: "all", URL: srv.URL + "/all", SecretEnv: "LB_TEST_WEBHOOK_SECRET"},
+		{Name: "scaling", URL: srv.URL + "/scaling", Types: []string{EventScaleUp}},
+	})
+	t.Cleanup(func() { webhookSinks = saved })
+
+	enqueueWebhooks(structers.Event{Type: EventScaleUp, Pool: "api"})
+	enqueueWebhooks(structers.Event{Type: EventReplicaFailed, Pool: "api"})
+	for _, sink := range webhookSinks {
+		close(sink.queue)
+	}
+	// returns once every queue is drained
+	DeliverWebhooks()
+
+	want := map[delivery]bool{
+		{"/all", EventScaleUp, true}:       true,
+		{"/all", EventReplicaFailed, true}: true,
+		{"/scaling", EventScaleUp, false}:  true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("deliveries = %+v, want %d", got, len(want))
+	}
+	for _, d := range got {
+		if !want[d] {
+			t.Errorf("unexpected delivery %+v", d)
+		}
+	}
+}
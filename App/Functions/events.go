@@ -0,0 +1,81 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// Event types
+const (
+	EventScaleUp         = "scale_up"
+	EventScaleDown       = "scale_down"
+	EventReplicaCreated  = "replica_created"
+	EventReplicaReplaced = "replica_replaced"
+	EventReplicaFailed   = "replica_failed"
+	EventPoolCrashLoop   = "pool_crashlooping"
+	EventReplicaExited   = "replica_exited"
+
+	EventDependencyDown      = "dependency_down"
+	EventDependencyUp        = "dependency_up"
+	EventDependencyRestarted = "dependency_restarted"
+)
+
+var (
+	// eventsMu guards events
+	eventsMu sync.Mutex
+
+	// events keeps the last config.MaxEvents events, oldest first
+	events []structers.Event
+)
+
+func init() {
+	metrics.Describe("lb_events_total", metrics.Counter, "Lifecycle events emitted, by type.")
+}
+
+// emitEvent records a lifecycle event that doesn't change the replica count.
+func emitEvent(eventType, pool, reason, containerID string) {
+	n := replicaCount()
+	publishEvent(structers.Event{
+		Type:        eventType,
+		Pool:        pool,
+		Reason:      reason,
+		ContainerID: containerID,
+		Before:      n,
+		After:       n,
+	})
+}
+
+// publishEvent records an event, logs it, counts it, appends it to the event log
+// and queues it for the webhooks. It must not be called with config.BackendsMu held.
+func publishEvent(ev structers.Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	eventsMu.Lock()
+	events = append(events, ev)
+	if len(events) > config.MaxEvents {
+		events = events[len(events)-config.MaxEvents:]
+	}
+	eventsMu.Unlock()
+
+	metrics.Inc("lb_events_total", "type", ev.Type)
+	log.Printf("event %s pool=%s container=%s replicas=%d->%d: %s", ev.Type, ev.Pool, ev.ContainerID, ev.Before, ev.After, ev.Reason)
+
+	writeEventLog(ev)
+	enqueueWebhooks(ev)
+}
+
+// recentEvents returns a copy of the kept events, oldest first.
+func recentEvents() []structers.Event {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return append([]structers.Event(nil), events...)
+}
@@ -0,0 +1,264 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// holtWinters is an additive Holt-Winters model of the request rate, fed one bucket at a time.
+type holtWinters struct {
+	// history is the rate of the closed buckets (req/s), at most two seasons, oldest first
+	history []float64
+
+	// ready is set once a full season was seen and the model initialized from it
+	ready    bool
+	level    float64
+	trend    float64
+	seasonal []float64
+	// idx is the seasonal index of the last observed bucket
+	idx int
+
+	// mae and mape are the smoothed absolute and relative errors of the one bucket ahead forecast
+	mae, mape float64
+	scored    int
+
+	// bucketStart and bucketCount accumulate the requests of the bucket being filled
+	bucketStart time.Time
+	bucketCount int64
+}
+
+// forecastView is what the admin API returns about the forecast.
+type forecastView struct {
+	Ready        bool          `json:"ready"`
+	Buckets      int           `json:"buckets"`
+	SeasonLength int           `json:"season_length"`
+	Level        float64       `json:"level"`
+	Trend        float64       `json:"trend"`
+	LeadTime     time.Duration `json:"lead_time_ns"`
+	Forecast     []float64     `json:"forecast_rps"`
+	MAE          float64       `json:"mae_rps"`
+	MAPE         float64       `json:"mape"`
+}
+
+var (
+	// forecastMu guards forecaster and startLatency
+	forecastMu sync.Mutex
+	forecaster holtWinters
+
+	// startLatency is the smoothed time a new replica takes to pass its startup probe
+	startLatency time.Duration
+)
+
+func init() {
+	metrics.Describe("lb_forecast_rps", metrics.Gauge, "Peak request rate forecast within the lead time.")
+	metrics.Describe("lb_forecast_next_rps", metrics.Gauge, "Request rate forecast for the next bucket.")
+	metrics.Describe("lb_forecast_lead_seconds", metrics.Gauge, "How far ahead the predictive scaling looks.")
+	metrics.Describe("lb_forecast_abs_error_rps", metrics.Gauge, "Smoothed absolute error of the one bucket ahead forecast.")
+	metrics.Describe("lb_forecast_error_ratio", metrics.Gauge, "Smoothed relative error of the one bucket ahead forecast.")
+}
+
+// seasonLength is the number of buckets in a season.
+func seasonLength() int {
+	return max(int(config.ForecastSeason/config.ForecastBucket), 1)
+}
+
+// observeRequests feeds the requests counted since the last call to the forecast.
+func observeRequests(count int64, at time.Time) {
+	if !config.ForecastEnabled {
+		return
+	}
+
+	forecastMu.Lock()
+	hw := &forecaster
+	if hw.bucketStart.IsZero() {
+		hw.bucketStart = at.Truncate(config.ForecastBucket)
+	}
+	// the requests since the last call mostly belong to the bucket being filled
+	hw.bucketCount += count
+
+	// close the buckets that ended, the ones without any observation had no traffic
+	closed := 0
+	for !at.Before(hw.bucketStart.Add(config.ForecastBucket)) {
+		rate := float64(hw.bucketCount) / config.ForecastBucket.Seconds()
+		closed++
+		hw.observe(rate)
+		hw.bucketStart = hw.bucketStart.Add(config.ForecastBucket)
+		hw.bucketCount = 0
+	}
+	next, mae, mape, ready := hw.forecast(1), hw.mae, hw.mape, hw.ready
+	forecastMu.Unlock()
+
+	if closed > 0 && ready {
+		metrics.Set("lb_forecast_next_rps", next)
+		metrics.Set("lb_forecast_abs_error_rps", mae)
+		metrics.Set("lb_forecast_error_ratio", mape)
+	}
+}
+
+// observe applies the rate of one closed bucket to the model.
+func (hw *holtWinters) observe(x float64) {
+	m := seasonLength()
+	hw.history = append(hw.history, x)
+	if len(hw.history) > 2*m {
+		hw.history = hw.history[len(hw.history)-2*m:]
+	}
+
+	if !hw.ready {
+		if len(hw.history) < m {
+			return
+		}
+		// initialize from the first full season: flat level, no trend
+		season := hw.history[len(hw.history)-m:]
+		hw.level = 0
+		for _, v := range season {
+			hw.level += v
+		}
+		hw.level /= float64(m)
+		hw.seasonal = make([]float64, m)
+		for i, v := range season {
+			hw.seasonal[i] = v - hw.level
+		}
+		hw.trend = 0
+		hw.idx = m - 1
+		hw.ready = true
+		return
+	}
+
+	// score the forecast made for this bucket before learning from it
+	predicted := hw.forecast(1)
+	errAbs := math.Abs(x - predicted)
+	hw.mae = smoothError(hw.mae, errAbs, hw.scored)
+	if x > 0 {
+		hw.mape = smoothError(hw.mape, errAbs/x, hw.scored)
+	}
+	hw.scored++
+
+	i := (hw.idx + 1) % m
+	prevLevel := hw.level
+	hw.level = config.ForecastAlpha*(x-hw.seasonal[i]) + (1-config.ForecastAlpha)*(hw.level+hw.trend)
+	hw.trend = config.ForecastBeta*(hw.level-prevLevel) + (1-config.ForecastBeta)*hw.trend
+	hw.seasonal[i] = config.ForecastGamma*(x-hw.level) + (1-config.ForecastGamma)*hw.seasonal[i]
+	hw.idx = i
+}
+
+// forecast returns the rate predicted h buckets after the last observed one, never negative.
+func (hw *holtWinters) forecast(h int) float64 {
+	if !hw.ready {
+		return 0
+	}
+	m := len(hw.seasonal)
+	return max(hw.level+float64(h)*hw.trend+hw.seasonal[(hw.idx+h)%m], 0)
+}
+
+// smoothError folds one error into its running average, the first ones weigh equally.
+func smoothError(avg, v float64, n int) float64 {
+	w := max(1/float64(n+1), config.ForecastAlpha)
+	return avg + w*(v-avg)
+}
+
+// noteStartLatency folds the time a replica took to get ready into the lead time.
+func noteStartLatency(d time.Duration) {
+	forecastMu.Lock()
+	defer forecastMu.Unlock()
+	if startLatency == 0 {
+		startLatency = d
+		return
+	}
+	startLatency = (3*startLatency + d) / 4
+}
+
+// forecastLeadTime is how far ahead the pool must be sized: the time a replica takes to
+// get ready, plus the controller interval since it only acts on its ticks.
+// forecastMu must be held.
+func forecastLeadTime() time.Duration {
+	lead := startLatency
+	if lead == 0 {
+		lead = config.DefaultStartLatency
+	}
+	return lead + config.ScaleInterval
+}
+
+// forecastStatus returns the state of the model and its forecast over the lead time.
+func forecastStatus() forecastView {
+	forecastMu.Lock()
+	defer forecastMu.Unlock()
+
+	hw := &forecaster
+	v := forecastView{
+		Ready:        hw.ready,
+		Buckets:      len(hw.history),
+		SeasonLength: seasonLength(),
+		Level:        hw.level,
+		Trend:        hw.trend,
+		LeadTime:     forecastLeadTime(),
+		MAE:          hw.mae,
+		MAPE:         hw.mape,
+	}
+	if hw.ready {
+		for h := 1; h <= leadBuckets(v.LeadTime); h++ {
+			v.Forecast = append(v.Forecast, hw.forecast(h))
+		}
+	}
+	return v
+}
+
+// leadBuckets is the number of buckets covering the lead time.
+func leadBuckets(lead time.Duration) int {
+	return max(int(math.Ceil(float64(lead)/float64(config.ForecastBucket))), 1)
+}
+
+// predictiveSignal sizes the pool for the peak rate forecast within the lead time, so the
+// replicas are ready when it comes. The reactive signals stay the safety net when it's wrong.
+type predictiveSignal struct{}
+
+func (predictiveSignal) Name() string { return "predictive" }
+
+func (predictiveSignal) Recommend(current int) (structers.ScalingRecommendation, error) {
+	if !config.ForecastEnabled {
+		return structers.ScalingRecommendation{}, errors.New("forecast disabled")
+	}
+
+	forecastMu.Lock()
+	hw := &forecaster
+	if !hw.ready {
+		n := len(hw.history)
+		forecastMu.Unlock()
+		return structers.ScalingRecommendation{}, fmt.Errorf("forecast warming up (%d/%d buckets)", n, seasonLength())
+	}
+	lead := forecastLeadTime()
+	peak := 0.0
+	for h := 1; h <= leadBuckets(lead); h++ {
+		peak = max(peak, hw.forecast(h))
+	}
+	forecastMu.Unlock()
+
+	metrics.Set("lb_forecast_rps", peak)
+	metrics.Set("lb_forecast_lead_seconds", lead.Seconds())
+
+	// the rate one replica is meant to take
+	perReplica := config.TargetRPSPerReplica
+	if perReplica <= 0 {
+		perReplica = float64(config.ScaleUpThreshold) / config.ScaleInterval.Seconds()
+	}
+	return structers.ScalingRecommendation{
+		Desired: int(math.Ceil(peak / perReplica)),
+		Reason:  fmt.Sprintf("forecast peak rps=%.1f within %v, %.1f per replica", peak, lead.Round(time.Second), perReplica),
+	}, nil
+}
+
+// takeRequestCount snapshots and resets the request counter, feeding it to the forecast.
+func takeRequestCount() int64 {
+	count := atomic.SwapInt64(&config.ReqCount, 0)
+	observeRequests(count, time.Now())
+	return count
+}
@@ -0,0 +1,126 @@
+package functions
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+)
+
+// useForecaster runs the test on a fresh model and start latency.
+func useForecaster(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		forecastMu.Lock()
+		forecaster = holtWinters{}
+		startLatency = 0
+		forecastMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestHoltWintersWarmsUpOverOneSeason(t *testing.T) {
+	m := seasonLength()
+	var hw holtWinters
+	for i := range m - 1 {
+		hw.observe(float64(i % 7))
+	}
+	if hw.ready || hw.forecast(1) != 0 {
+		t.Fatalf("ready=%v forecast=%v after %d buckets, want nothing before a full season", hw.ready, hw.forecast(1), m-1)
+	}
+	hw.observe(0)
+	if !hw.ready {
+		t.Fatalf("not ready after a full season of %d buckets", m)
+	}
+}
+
+func TestHoltWintersForecastsAPeriodicSeries(t *testing.T) {
+	m := seasonLength()
+	// a daily wave with a sharper morning peak
+	rate := func(i int) float64 {
+		phase := 2 * math.Pi * float64(i%m) / float64(m)
+		return 100 + 50*math.Sin(phase) + 20*math.Max(0, math.Sin(3*phase))
+	}
+
+	var hw holtWinters
+	// 2 days and a half, the forecasts cross into the next day
+	n := 2*m + m/2
+	for i := range n {
+		hw.observe(rate(i))
+	}
+
+	for _, h := range []int{1, 10, 60, m / 2, m/2 + 30, m + 10} {
+		want := rate(n - 1 + h)
+		if got := hw.forecast(h); math.Abs(got-want) > 0.01*want {
+			t.Errorf("forecast %d buckets ahead = %.2f, want %.2f (1%%)", h, got, want)
+		}
+	}
+	if hw.scored != n-m {
+		t.Errorf("%d forecasts scored, want one per bucket after the first season (%d)", hw.scored, n-m)
+	}
+	if hw.mape > 0.01 {
+		t.Errorf("smoothed relative error = %.4f, want under 1%%", hw.mape)
+	}
+}
+
+func TestPredictiveSignalSizesForThePeakWithinTheLeadTime(t *testing.T) {
+	useForecaster(t)
+	m := seasonLength()
+	// a daily burst at minute 600 that lasts 10 buckets
+	rate := func(i int) float64 {
+		if i%m >= 600 && i%m < 610 {
+			return 200
+		}
+		return 10
+	}
+
+	forecastMu.Lock()
+	// 3 days, up to 5 buckets before the burst
+	for i := range 2*m + 595 {
+		forecaster.observe(rate(i))
+	}
+	startLatency = 10 * time.Minute
+	next := forecaster.forecast(1)
+	forecastMu.Unlock()
+
+	rec, err := predictiveSignal{}.Recommend(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perReplica := config.TargetRPSPerReplica
+	if perReplica <= 0 {
+		perReplica = float64(config.ScaleUpThreshold) / config.ScaleInterval.Seconds()
+	}
+	// the next bucket is calm, the burst comes within the 10m lead time
+	if calm := int(math.Ceil(next / perReplica)); rec.Desired <= calm {
+		t.Errorf("desired = %d (%s), want more than the %d the next bucket needs", rec.Desired, rec.Reason, calm)
+	}
+	if want := int(math.Ceil(150 / perReplica)); rec.Desired < want {
+		t.Errorf("desired = %d (%s), want %d at least for the burst", rec.Desired, rec.Reason, want)
+	}
+}
+
+func TestObserveRequestsClosesTheEndedBuckets(t *testing.T) {
+	useForecaster(t)
+	t0 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	observeRequests(30, t0.Add(10*time.Second))
+	observeRequests(30, t0.Add(50*time.Second))
+	// two buckets without a request went by meanwhile
+	observeRequests(0, t0.Add(3*config.ForecastBucket+time.Second))
+
+	forecastMu.Lock()
+	defer forecastMu.Unlock()
+	want := []float64{60 / config.ForecastBucket.Seconds(), 0, 0}
+	if len(forecaster.history) != len(want) {
+		t.Fatalf("history = %v, want %v", forecaster.history, want)
+	}
+	for i := range want {
+		if forecaster.history[i] != want[i] {
+			t.Errorf("history = %v, want %v", forecaster.history, want)
+			break
+		}
+	}
+}
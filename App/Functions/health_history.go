@@ -0,0 +1,152 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// Subsystems changing the health state of a backend
+const (
+	SourceProbe   = "probe"
+	SourcePassive = "passive"
+	SourceScaling = "scaling"
+)
+
+// backendHistory is what the admin API returns for one backend.
+type backendHistory struct {
+	ContainerID string                       `json:"container_id"`
+	URL         string                       `json:"url"`
+	State       string                       `json:"state"`
+	History     []structers.HealthTransition `json:"history"`
+}
+
+// retiredBackend is a backend on its way out of the pool, kept so its history can still be
+// queried once it left the lists.
+type retiredBackend struct {
+	b  *structers.Backend
+	at time.Time
+}
+
+var (
+	// retiredBackends is keyed by container ID, guarded by config.BackendsMu
+	retiredBackends = map[string]retiredBackend{}
+)
+
+// healthState names the current health state of the backend.
+func healthState(b *structers.Backend) string {
+	switch {
+	case b.Draining:
+		return "draining"
+	case atomic.LoadInt32(&b.ShuttingDown) == 1:
+		return "shutting_down"
+	case b.Starting:
+		return "starting"
+	case b.Alive && !b.Ill:
+		return "alive"
+	case b.Alive && b.Ill:
+		return "ill"
+	default:
+		return "dead"
+	}
+}
+
+// noteTransition records the state change of b, if there's one, with the probe behind it.
+// config.BackendsMu must be held.
+func noteTransition(b *structers.Backend, source string, res probeResult) {
+	state := healthState(b)
+	if state == b.State {
+		return
+	}
+
+	t := structers.HealthTransition{
+		Time:       time.Now(),
+		From:       b.State,
+		To:         state,
+		Source:     source,
+		Latency:    res.latency,
+		StatusCode: res.status,
+	}
+	if res.err != nil {
+		t.Error = res.err.Error()
+	}
+
+	b.State = state
+	b.History = append(b.History, t)
+	if len(b.History) > config.MaxHealthHistory {
+		b.History = b.History[len(b.History)-config.MaxHealthHistory:]
+	}
+
+	switch state {
+	case "draining", "shutting_down":
+		retireBackend(b, t.Time)
+	default:
+		// back in the pool, e.g. its close failed
+		delete(retiredBackends, b.ContainerID)
+	}
+}
+
+// retireBackend keeps b in retiredBackends, evicting the expired ones and then the oldest
+// ones past RetiredHistories. config.BackendsMu must be held.
+func retireBackend(b *structers.Backend, now time.Time) {
+	retiredBackends[b.ContainerID] = retiredBackend{b: b, at: now}
+	pruneRetiredBackends(now)
+	for len(retiredBackends) > config.RetiredHistories {
+		oldest := ""
+		for id, r := range retiredBackends {
+			if oldest == "" || r.at.Before(retiredBackends[oldest].at) {
+				oldest = id
+			}
+		}
+		delete(retiredBackends, oldest)
+	}
+}
+
+// pruneRetiredBackends drops the backends retired more than RetiredHistoryTTL ago.
+// config.BackendsMu must be held.
+func pruneRetiredBackends(now time.Time) {
+	for id, r := range retiredBackends {
+		if now.Sub(r.at) > config.RetiredHistoryTTL {
+			delete(retiredBackends, id)
+		}
+	}
+}
+
+// healthHistory returns the history of every known backend, the recently retired ones
+// included, or only of the one running containerID when it's set.
+func healthHistory(containerID string) []backendHistory {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	pruneRetiredBackends(time.Now())
+	retired := make(structers.BackendHeap, 0, len(retiredBackends))
+	for _, r := range retiredBackends {
+		retired = append(retired, r.b)
+	}
+	slices.SortFunc(retired, func(a, b *structers.Backend) int {
+		return retiredBackends[a.ContainerID].at.Compare(retiredBackends[b.ContainerID].at)
+	})
+
+	seen := map[*structers.Backend]bool{}
+	var out []backendHistory
+	for _, list := range []structers.BackendHeap{config.Backends, config.Unhealthy, retired} {
+		for _, b := range list {
+			if seen[b] || (containerID != "" && b.ContainerID != containerID) {
+				continue
+			}
+			seen[b] = true
+			out = append(out, backendHistory{
+				ContainerID: b.ContainerID,
+				URL:         b.URL.String(),
+				State:       healthState(b),
+				History:     append([]structers.HealthTransition(nil), b.History...),
+			})
+		}
+	}
+	return out
+}
@@ -4,12 +4,23 @@ package functions
 
 import (
 	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/xaydras-2/loadBalancer/App/config"
@@ -17,64 +28,183 @@ import (
 )
 
 var (
+	// httpClient is used by the health probes, each probe is bounded by the Timeout of its spec
 	httpClient = &http.Client{
-		Timeout: 5 * time.Second,
 		Transport: &http.Transport{
 			MaxIdleConnsPerHost: 100,
 			IdleConnTimeout:     30 * time.Second,
 			DisableKeepAlives:   false, // ensure keep-alive
 		},
 	}
+
+	// healthRegexMu guards healthRegexes, the compiled BodyRegex of the specs
+	healthRegexMu sync.Mutex
+	healthRegexes = map[string]*regexp.Regexp{}
 )
 
-// checkAlive sends a GET request to the given backend's /healthz page and
-// returns whether the response was successful (200-399), the latency of the
-// request, and any error that occurred.
-func checkAlive(b *structers.Backend) (bool, time.Duration, error) {
-	// skip if dead
-	if !b.Alive && !b.Ill {
-		return false, 0, nil
+// maxHealthBodySize caps how much of a probe response is read to match the body
+const maxHealthBodySize = 64 << 10
+
+// healthSpecFor returns the health check spec of the pool the backend belongs to.
+func healthSpecFor(b *structers.Backend) structers.HealthCheckSpec {
+	if spec, ok := config.HealthChecks[b.Pool]; ok {
+		return spec
 	}
+	return config.DefaultHealthCheck
+}
+
+// probeResult is the outcome of one health probe.
+type probeResult struct {
+	ok      bool
+	status  int // 0 when no response came back
+	latency time.Duration
+	err     error
+}
 
+// checkAlive probes the given backend as described by its pool health check spec and
+// returns whether the response was the expected one, its status code, the latency of the
+// request, and any error that occurred.
+func checkAlive(b *structers.Backend) probeResult {
 	if atomic.LoadInt32(&b.ShuttingDown) == 1 {
-		return false, 0, nil
+		return probeResult{}
+	}
+
+	spec := healthSpecFor(b)
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
 	}
 
-	healthURL := b.URL.ResolveReference(&url.URL{Path: "/healthz"})
-	req, err := http.NewRequest(http.MethodGet, healthURL.String(), nil)
+	timeout := spec.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultHealthCheck.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	healthURL := b.URL.ResolveReference(&url.URL{Path: spec.Path})
+	req, err := http.NewRequestWithContext(ctx, method, healthURL.String(), nil)
 	if err != nil {
-		return false, 0, err
+		return probeResult{err: err}
+	}
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
 	}
+
 	start := time.Now()
 	resp, err := httpClient.Do(req)
 	latency := time.Since(start)
 
 	if err != nil {
-		return false, latency, err
+		return probeResult{latency: latency, err: err}
 	}
+	defer resp.Body.Close()
+
+	ok, err := matchesHealthSpec(resp, spec)
 
 	// drain the body so the connection can be reused cleanly
 	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	return resp.StatusCode < 400, latency, nil
+	return probeResult{ok: ok, status: resp.StatusCode, latency: latency, err: err}
 }
 
+// matchesHealthSpec checks the probe response against the expected status and body of the spec.
+// A mismatch is reported as an error so the reason shows up in the logs.
+func matchesHealthSpec(resp *http.Response, spec structers.HealthCheckSpec) (bool, error) {
+	if len(spec.ExpectedStatus) == 0 {
+		if resp.StatusCode >= 400 {
+			return false, fmt.Errorf("unhealthy status %d", resp.StatusCode)
+		}
+	} else if !slices.Contains(spec.ExpectedStatus, resp.StatusCode) {
+		return false, fmt.Errorf("unexpected status %d, want one of %v", resp.StatusCode, spec.ExpectedStatus)
+	}
+
+	if spec.BodyRegex == "" && spec.JSONField == "" {
+		return true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+	if err != nil {
+		return false, fmt.Errorf("read health body: %w", err)
+	}
+
+	if spec.BodyRegex != "" {
+		re, err := healthRegex(spec.BodyRegex)
+		if err != nil {
+			return false, err
+		}
+		if !re.Match(body) {
+			return false, fmt.Errorf("body doesn't match %q", spec.BodyRegex)
+		}
+	}
+
+	if spec.JSONField != "" {
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return false, fmt.Errorf("decode health body: %w", err)
+		}
+		for _, key := range strings.Split(spec.JSONField, ".") {
+			obj, ok := doc.(map[string]any)
+			if !ok {
+				return false, fmt.Errorf("field %q not found", spec.JSONField)
+			}
+			doc = obj[key]
+		}
+		if got := fmt.Sprint(doc); got != spec.JSONValue {
+			return false, fmt.Errorf("field %q is %q, want %q", spec.JSONField, got, spec.JSONValue)
+		}
+	}
+
+	return true, nil
+}
+
+// healthRegex compiles the body regex once and caches it.
+func healthRegex(expr string) (*regexp.Regexp, error) {
+	healthRegexMu.Lock()
+	defer healthRegexMu.Unlock()
+
+	if re, ok := healthRegexes[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health body regex %q: %w", expr, err)
+	}
+	healthRegexes[expr] = re
+	return re, nil
+}
+
+// StartHealthChecker probes every backend on its own schedule: each Interval (plus a random
+// Jitter) of its pool spec, with a backoff once dead, and right away when it's new.
+// The due backends are probed concurrently, at most HealthCheckWorkers at a time.
 func StartHealthChecker() {
-	ticker := time.NewTicker(config.ScaleInterval)
+	ticker := time.NewTicker(config.HealthCheckTick)
 	defer ticker.Stop()
 
-	// keeping the same “snapshot” pattern for the periodic scan.
 	for {
 		select {
 		case <-ticker.C:
-			runAllChecks()
 		case b := <-config.NewBackendTrigger:
-			// immediately check this one new backend
-			checkAndReheap(b)
+			// make this one due now, it's probed with the rest of the batch
+			config.BackendsMu.Lock()
+			b.NextProbe = time.Time{}
+			config.BackendsMu.Unlock()
 		}
+		runAllChecks()
 	}
 }
 
+// nextProbeTime returns when a backend with this spec should be probed again.
+func nextProbeTime(spec structers.HealthCheckSpec) time.Time {
+	d := spec.Interval
+	if d <= 0 {
+		d = config.DefaultHealthCheck.Interval
+	}
+	if spec.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(spec.Jitter)))
+	}
+	return time.Now().Add(d)
+}
+
 func appendIfNewUnhealthy(b *structers.Backend) {
 	for _, ub := range config.Unhealthy {
 		if ub == b {
@@ -84,61 +214,120 @@ func appendIfNewUnhealthy(b *structers.Backend) {
 	config.Unhealthy = append(config.Unhealthy, b)
 }
 
-func runAllChecks() {
-	backends := snapshotAllBackends()
-
-	for _, b := range backends {
-		ok, _, _ := checkAlive(b)
-
-		config.BackendsMu.Lock()
-
-		if ok {
-			handleRecoveredBackend(b)
-		} else {
-			// Health check failed
-			if !b.Alive {
-				// Already dead, just add to unhealthy if not already there
-				appendIfNewUnhealthy(b)
-			} else {
-				// Still marked as alive but failing health check
-				handleFailingBackend(b)
-				// Only add to unhealthy if it's now dead or ill
-				if !b.Alive || b.Ill {
-					appendIfNewUnhealthy(b)
-				}
+// dueBackends returns the backends, healthy or not, whose next probe is due.
+func dueBackends(now time.Time) []*structers.Backend {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	seen := make(map[*structers.Backend]bool, config.Backends.Len()+len(config.Unhealthy))
+	var due []*structers.Backend
+	for _, list := range []structers.BackendHeap{config.Backends, config.Unhealthy} {
+		for _, b := range list {
+			// an ill backend sits in both lists, a starting one is polled by its startup probe
+			if seen[b] || b.Starting || atomic.LoadInt32(&b.ShuttingDown) == 1 || now.Before(b.NextProbe) {
+				continue
 			}
+			seen[b] = true
+			due = append(due, b)
 		}
+	}
+	return due
+}
+
+// runAllChecks probes the due backends with a bounded worker pool, then applies
+// all the results in one go under config.BackendsMu.
+func runAllChecks() {
+	due := dueBackends(time.Now())
+	if len(due) == 0 {
+		return
+	}
 
-		config.BackendsMu.Unlock()
+	results := make([]probeResult, len(due))
+	sem := make(chan struct{}, config.HealthCheckWorkers)
+	var wg sync.WaitGroup
+	for i, b := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = checkAlive(b)
+		}()
+	}
+	wg.Wait()
+
+	config.BackendsMu.Lock()
+	for i, b := range due {
+		applyCheckResult(b, results[i])
+	}
+	config.BackendsMu.Unlock()
+
+	for i, b := range due {
+		if results[i].err != nil {
+			log.Printf("health check %s: %v", b.URL.String(), results[i].err)
+		}
 	}
 }
 
 func checkAndReheap(b *structers.Backend) {
 	log.Printf("checking the backend b: %v", b)
-	ok, _, _ := checkAlive(b)
+	res := checkAlive(b)
+	if res.err != nil {
+		log.Printf("health check %s: %v", b.URL.String(), res.err)
+	}
 
 	config.BackendsMu.Lock()
 	defer config.BackendsMu.Unlock()
 
-	if ok {
-		handleRecoveredBackend(b)
+	applyCheckResult(b, res)
+}
+
+// applyCheckResult updates the backend health with one probe outcome and files it in the
+// heap or in the unhealthy list accordingly. config.BackendsMu must be held.
+func applyCheckResult(b *structers.Backend, res probeResult) {
+	// being scaled down or replaced, it's not ours to file anymore
+	if atomic.LoadInt32(&b.ShuttingDown) == 1 {
 		return
 	}
 
-	// Health check failed
-	if !b.Alive {
-		// Already marked dead, just collect it
-		appendIfNewUnhealthy(b)
+	if !res.ok && DependencyDegraded() && dependencyFailure(b, res) {
+		// failing because of the dependency, not by itself: don't kill it
+		b.NextProbe = nextProbeTime(healthSpecFor(b))
 		return
 	}
 
-	// Still alive but failing health check
-	handleFailingBackend(b)
+	if res.ok {
+		handleRecoveredBackend(b)
+	} else if !b.Alive {
+		// Already dead (or not ready yet), reset its streak of successes
+		b.ConsecutiveOKs = 0
+		b.ConsecutiveFails++
+		if !b.DeadSince.IsZero() {
+			scheduleDeadProbe(b)
+		}
+	} else {
+		// Still marked as alive but failing health check
+		handleFailingBackend(b)
+	}
 
-	// Add to unhealthy if now dead or ill
+	// Only add to unhealthy if it's dead, ill or not recovered yet
 	if !b.Alive || b.Ill {
 		appendIfNewUnhealthy(b)
 	}
+
+	// a dead backend got its backoff from scheduleDeadProbe
+	if b.DeadSince.IsZero() {
+		b.NextProbe = nextProbeTime(healthSpecFor(b))
+	}
+
+	noteTransition(b, SourceProbe, res)
+}
+
+// dependencyFailure reports whether a failed probe may be the fault of a dependency: the
+// replica answered badly or too slowly. A refused connection or an exited container means
+// the replica itself is gone, dependency down or not. config.BackendsMu must be held.
+func dependencyFailure(b *structers.Backend, res probeResult) bool {
+	return !b.Exited && !errors.Is(res.err, syscall.ECONNREFUSED)
 }
 
 // pickBackendAndIncrement it peaks the first backend of the heap, since the backend heap is auto ordered by less,
@@ -174,25 +363,48 @@ func pickBackendAndIncrement() *structers.Backend {
 // and passes the request to it
 func ProxyHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if config.Backends == nil || config.Backends.Len() == 0 {
-			http.Error(w, "no backends available", http.StatusServiceUnavailable)
+		// a dependency is down, the replicas can't answer properly anyway
+		if DependencyDegraded() {
+			serveDegraded(w)
 			return
 		}
 
 		// Pick backend and increment load atomically
 		b := pickBackendAndIncrement()
-		if b == nil {
-			http.Error(w, "no backends available", http.StatusServiceUnavailable)
-			return
+		queued := b == nil
+		if queued {
+			// none healthy right now (e.g. a replica is still starting), wait for one
+			timeout := config.BackendQueueTimeout
+			if coldStarting() {
+				// scaled to zero, hold the request while a replica is activated
+				activateFromZero()
+				timeout = config.ActivationTimeout
+			} else if !config.BackendQueueEnabled {
+				http.Error(w, "no backends available", http.StatusServiceUnavailable)
+				return
+			}
+
+			var waited time.Duration
+			var err error
+			b, waited, err = waitForBackend(r, timeout)
+			if err != nil {
+				log.Printf("no backend for %s %s after %v: %v", r.Method, r.URL.Path, waited, err)
+				http.Error(w, "no backends available", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set(config.QueueTimeHeader, strconv.FormatInt(waited.Milliseconds(), 10)+"ms")
 		}
 
+		// Sample the request for the shadow pool before the primary consumes the body
+		shadow := startMirror(r)
+
 		// Ensure load is decremented when request completes
 		defer func() {
 			atomic.AddInt64(&b.CurrentLoad, -1)
 
 			config.BackendsMu.Lock()
-			// Verify backend still exists in heap before fixing
-			if b.HeapIdx >= 0 && b.HeapIdx < config.Backends.Len() {
+			// Verify backend still exists in heap before fixing (a draining one left it)
+			if inHeap(b) {
 				heap.Fix(&config.Backends, b.HeapIdx)
 			}
 			config.BackendsMu.Unlock()
@@ -211,7 +423,40 @@ func ProxyHandler() http.HandlerFunc {
 				http.Error(rw, "Proxy error: "+err.Error(), http.StatusBadGateway)
 			},
 		}
-		proxy.ServeHTTP(w, r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		proxy.ServeHTTP(rec, r)
+		rtt := time.Since(start)
+		recordLatency(rtt)
+		if !queued {
+			reportUpstreamRTT(r, rtt, rec.status)
+		}
 
+		if shadow != nil {
+			go compareMirror(shadow, r.Method, r.URL.Path, rec.status, time.Since(start))
+		}
 	}
 }
+
+// statusRecorder wraps a ResponseWriter to remember the status code sent to the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
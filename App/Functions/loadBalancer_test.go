@@ -0,0 +1,172 @@
+package functions
+
+import (
+	"container/heap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+func TestMatchesHealthSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   structers.HealthCheckSpec
+		status int
+		body   string
+		ok     bool
+		err    string
+	}{
+		{name: "default accepts 2xx", status: 200, ok: true},
+		{name: "default accepts 3xx", status: 399, ok: true},
+		{name: "default rejects 4xx", status: 400, err: "unhealthy status 400"},
+		{name: "default rejects 5xx", status: 503, err: "unhealthy status 503"},
+		{name: "listed status", spec: structers.HealthCheckSpec{ExpectedStatus: []int{200, 204}}, status: 204, ok: true},
+		{name: "unlisted 2xx", spec: structers.HealthCheckSpec{ExpectedStatus: []int{204}}, status: 200, err: "unexpected status 200"},
+		{name: "listed error status", spec: structers.HealthCheckSpec{ExpectedStatus: []int{503}}, status: 503, ok: true},
+
+		{name: "body matches", spec: structers.HealthCheckSpec{BodyRegex: `^OK\b`}, status: 200, body: "OK all good", ok: true},
+		{name: "body doesn't match", spec: structers.HealthCheckSpec{BodyRegex: `^OK\b`}, status: 200, body: "DEGRADED", err: "doesn't match"},
+		{name: "invalid regex", spec: structers.HealthCheckSpec{BodyRegex: `(`}, status: 200, body: "OK", err: "invalid health body regex"},
+		{name: "status checked before the body", spec: structers.HealthCheckSpec{BodyRegex: `OK`}, status: 500, body: "OK", err: "unhealthy status 500"},
+
+		{name: "json field", spec: structers.HealthCheckSpec{JSONField: "status", JSONValue: "up"}, status: 200, body: `{"status": "up"}`, ok: true},
+		{name: "nested json field", spec: structers.HealthCheckSpec{JSONField: "checks.db", JSONValue: "ok"}, status: 200, body: `{"checks": {"db": "ok"}}`, ok: true},
+		{name: "non string json field", spec: structers.HealthCheckSpec{JSONField: "checks.ready", JSONValue: "true"}, status: 200, body: `{"checks": {"ready": true}}`, ok: true},
+		{name: "json field mismatch", spec: structers.HealthCheckSpec{JSONField: "checks.db", JSONValue: "ok"}, status: 200, body: `{"checks": {"db": "down"}}`, err: `is "down", want "ok"`},
+		{name: "json field missing", spec: structers.HealthCheckSpec{JSONField: "checks.db.primary", JSONValue: "ok"}, status: 200, body: `{"checks": {"db": "ok"}}`, err: "not found"},
+		{name: "not json", spec: structers.HealthCheckSpec{JSONField: "status", JSONValue: "up"}, status: 200, body: "up", err: "decode health body"},
+		{name: "regex and json field", spec: structers.HealthCheckSpec{BodyRegex: `"up"`, JSONField: "status", JSONValue: "up"}, status: 200, body: `{"status": "up"}`, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}
+			ok, err := matchesHealthSpec(resp, tt.spec)
+			if ok != tt.ok {
+				t.Errorf("ok = %v, want %v (err: %v)", ok, tt.ok, err)
+			}
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckAliveSendsTheProbeOfItsPool(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	const pool = "health-test"
+	config.HealthChecks[pool] = structers.HealthCheckSpec{
+		Path:           "/ready",
+		Method:         http.MethodHead,
+		Headers:        map[string]string{"Authorization": "Bearer probe"},
+		ExpectedStatus: []int{http.StatusNoContent},
+	}
+	defer delete(config.HealthChecks, pool)
+
+	u, _ := url.Parse(srv.URL)
+	res := checkAlive(&structers.Backend{URL: u, Pool: pool})
+	if !res.ok || res.status != http.StatusNoContent {
+		t.Fatalf("probe = %+v, want ok with 204", res)
+	}
+	if got.Method != http.MethodHead || got.URL.Path != "/ready" || got.Header.Get("Authorization") != "Bearer probe" {
+		t.Errorf("probe sent %s %s with Authorization %q, want HEAD /ready with the spec's header",
+			got.Method, got.URL.Path, got.Header.Get("Authorization"))
+	}
+}
+
+// setDependencyDown degrades the pool with a dependency down until the returned func
+// (or the end of the test) brings it back up.
+func setDependencyDown(t *testing.T, name string) func() {
+	t.Helper()
+	dependenciesMu.Lock()
+	dependencyStates[name] = &dependencyState{Name: name}
+	dependenciesMu.Unlock()
+
+	up := func() {
+		dependenciesMu.Lock()
+		delete(dependencyStates, name)
+		dependenciesMu.Unlock()
+	}
+	t.Cleanup(up)
+	return up
+}
+
+func TestApplyCheckResultWhileDegraded(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// what a replica whose database is gone answers
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	crashed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	crashed.Close()
+
+	const pool = "degraded-test"
+	spec := config.DefaultHealthCheck
+	spec.Timeout = 50 * time.Millisecond
+	config.HealthChecks[pool] = spec
+	defer delete(config.HealthChecks, pool)
+
+	tests := []struct {
+		name   string
+		url    string
+		exited bool
+		// dead is whether UnhealthyThreshold failures kill it
+		dead bool
+	}{
+		{name: "error status", url: failing.URL},
+		{name: "timeout", url: slow.URL},
+		{name: "connection refused", url: crashed.URL, dead: true},
+		{name: "container exited", url: failing.URL, exited: true, dead: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(resetPool)
+			setDependencyDown(t, "degraded-test-db")
+
+			u, _ := url.Parse(tt.url)
+			b := &structers.Backend{URL: u, Pool: pool, Alive: true, Exited: tt.exited}
+			config.BackendsMu.Lock()
+			heap.Push(&config.Backends, b)
+			config.BackendsMu.Unlock()
+
+			for range spec.UnhealthyThreshold {
+				res := checkAlive(b)
+				if res.ok {
+					t.Fatalf("probe passed, want a failure")
+				}
+				config.BackendsMu.Lock()
+				applyCheckResult(b, res)
+				config.BackendsMu.Unlock()
+			}
+
+			config.BackendsMu.Lock()
+			defer config.BackendsMu.Unlock()
+			if b.Alive == tt.dead || inHeap(b) == tt.dead {
+				t.Errorf("alive=%v in rotation=%v after %d failures, want dead=%v",
+					b.Alive, inHeap(b), spec.UnhealthyThreshold, tt.dead)
+			}
+			if !tt.dead && (b.Ill || b.ConsecutiveFails != 0) {
+				t.Errorf("ill=%v with %d failures counted, want the dependency's fault ignored", b.Ill, b.ConsecutiveFails)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// loadMu guards the load samples taken since the last evaluation
+	loadMu      sync.Mutex
+	loadSum     int64
+	loadPeak    int64
+	loadSamples int
+
+	// loadBackends sums the replicas in rotation each sample was taken over
+	loadBackends int
+)
+
+func init() {
+	metrics.Describe("lb_pool_inflight_requests", metrics.Gauge, "Requests in flight on the replicas in rotation at the last sample.")
+	metrics.Describe("lb_pool_inflight_avg", metrics.Gauge, "Average requests in flight on the pool over the last scaling interval.")
+}
+
+// SampleLoad sums the CurrentLoad of the replicas in rotation every LoadSampleInterval,
+// the concurrency signal uses the average. Meant to run in its own goroutine.
+func SampleLoad() {
+	ticker := time.NewTicker(config.LoadSampleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		config.BackendsMu.Lock()
+		var total int64
+		for _, b := range config.Backends {
+			total += atomic.LoadInt64(&b.CurrentLoad)
+		}
+		backends := config.Backends.Len()
+		config.BackendsMu.Unlock()
+
+		loadMu.Lock()
+		loadSum += total
+		loadBackends += backends
+		loadPeak = max(loadPeak, total)
+		loadSamples++
+		loadMu.Unlock()
+
+		metrics.Set("lb_pool_inflight_requests", float64(total))
+	}
+}
+
+// takeLoad returns the average and the peak of the in-flight requests sampled since the
+// last call, the average replicas in rotation they were spread over and the number of
+// samples, and resets them.
+func takeLoad() (float64, int64, float64, int) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	n, sum, peak, backends := loadSamples, loadSum, loadPeak, loadBackends
+	loadSum, loadPeak, loadSamples, loadBackends = 0, 0, 0, 0
+	if n == 0 {
+		return 0, 0, 0, 0
+	}
+	return float64(sum) / float64(n), peak, float64(backends) / float64(n), n
+}
+
+// concurrencySignal tracks TargetConcurrencyPerReplica: it asks for the replicas the average
+// in flight needs at the target, ceil(avg / target).
+type concurrencySignal struct{}
+
+func (concurrencySignal) Name() string { return "concurrency" }
+
+func (concurrencySignal) Recommend(current int) (structers.ScalingRecommendation, error) {
+	avg, peak, backends, n := takeLoad()
+	if config.TargetConcurrencyPerReplica <= 0 {
+		return structers.ScalingRecommendation{}, errors.New("no concurrency target")
+	}
+	if n == 0 {
+		return structers.ScalingRecommendation{}, errors.New("no load sample")
+	}
+	metrics.Set("lb_pool_inflight_avg", avg)
+	// the load is spread over the replicas in rotation only, not the starting or draining ones
+	if current == 0 || backends == 0 {
+		return structers.ScalingRecommendation{}, errors.New("no replica in rotation")
+	}
+
+	// sized from the total in flight: current also counts the starting and draining
+	// replicas, scaling it by the per-replica ratio would overshoot
+	desired := current
+	if perReplica := avg / backends; math.Abs(perReplica/config.TargetConcurrencyPerReplica-1) > config.TargetTolerance {
+		desired = int(math.Ceil(avg / config.TargetConcurrencyPerReplica))
+	}
+	return structers.ScalingRecommendation{
+		Desired: desired,
+		Reason: fmt.Sprintf("in-flight avg=%.1f (peak %d) over %.1f replicas in rotation, target %.0f per replica",
+			avg, peak, backends, config.TargetConcurrencyPerReplica),
+	}, nil
+}
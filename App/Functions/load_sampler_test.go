@@ -0,0 +1,55 @@
+package functions
+
+import "testing"
+
+func TestConcurrencySignalSizesFromTheLoadInRotation(t *testing.T) {
+	// with TargetConcurrencyPerReplica = 8
+	tests := []struct {
+		name string
+		// current counts the replicas starting and draining too, backends only those in rotation
+		current  int
+		backends int
+		inflight int64
+		want     int
+	}{
+		{name: "on target", current: 2, backends: 2, inflight: 16, want: 2},
+		{name: "within tolerance", current: 3, backends: 2, inflight: 17, want: 3},
+		{name: "overloaded", current: 2, backends: 2, inflight: 32, want: 4},
+		{name: "overloaded while one starts", current: 3, backends: 2, inflight: 32, want: 4},
+		{name: "overloaded while two drain", current: 4, backends: 2, inflight: 24, want: 3},
+		{name: "idle with one starting", current: 3, backends: 2, inflight: 4, want: 1},
+		{name: "idle", current: 2, backends: 2, inflight: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			takeLoad()
+			loadMu.Lock()
+			// two samples over the same replicas
+			loadSum, loadPeak, loadSamples, loadBackends = 2*tt.inflight, tt.inflight, 2, 2*tt.backends
+			loadMu.Unlock()
+			t.Cleanup(func() { takeLoad() })
+
+			rec, err := concurrencySignal{}.Recommend(tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Desired != tt.want {
+				t.Errorf("desired = %d, want %d (%s)", rec.Desired, tt.want, rec.Reason)
+			}
+		})
+	}
+}
+
+func TestConcurrencySignalNeedsSamplesInRotation(t *testing.T) {
+	takeLoad()
+	if _, err := (concurrencySignal{}).Recommend(2); err == nil {
+		t.Error("recommendation without a sample")
+	}
+
+	loadMu.Lock()
+	loadSum, loadSamples, loadBackends = 10, 1, 0
+	loadMu.Unlock()
+	if _, err := (concurrencySignal{}).Recommend(2); err == nil {
+		t.Error("recommendation without a replica in rotation")
+	}
+}
@@ -0,0 +1,140 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+)
+
+var (
+	mirrorClient = &http.Client{
+		Timeout: config.MirrorTimeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: 100,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		// the shadow answer is discarded, no need to follow it anywhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// mirrorNext is the round robin cursor over config.ShadowPool
+	mirrorNext uint64
+)
+
+// mirrorResult is what the shadow pool answered to a mirrored request.
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// startMirror samples the request and, when picked, sends a copy of it to the shadow pool.
+// The body is buffered (up to MirrorMaxBodySize) and r.Body is replaced so the primary
+// can still read it. It returns nil when the request isn't mirrored, otherwise a channel
+// that receives the shadow result once it's in.
+func startMirror(r *http.Request) <-chan mirrorResult {
+	if len(config.ShadowPool) == 0 || rand.Float64() >= config.MirrorSampleRate {
+		return nil
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		buf, err := io.ReadAll(io.LimitReader(r.Body, config.MirrorMaxBodySize+1))
+		if err != nil {
+			// the primary will see the same broken body, let it answer
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), r.Body))
+			return nil
+		}
+		if len(buf) > config.MirrorMaxBodySize {
+			// too big, give the primary back what we read and skip the mirror
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+			atomic.AddInt64(&config.Mirror.Skipped, 1)
+			return nil
+		}
+		r.Body.Close()
+		body = buf
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	target := config.ShadowPool[atomic.AddUint64(&mirrorNext, 1)%uint64(len(config.ShadowPool))]
+	base, err := url.Parse(target)
+	if err != nil {
+		log.Printf("mirror: invalid shadow URL %q: %v", target, err)
+		return nil
+	}
+
+	method, uri, header := r.Method, r.URL.RequestURI(), r.Header.Clone()
+	out := make(chan mirrorResult, 1)
+	// detached from the client context, the mirror must never cut nor slow the client
+	go func() {
+		out <- sendMirror(base, method, uri, header, body)
+	}()
+	atomic.AddInt64(&config.Mirror.Mirrored, 1)
+	return out
+}
+
+// sendMirror replays the request on the shadow back-end and drains its response.
+func sendMirror(base *url.URL, method, uri string, header http.Header, body []byte) mirrorResult {
+	ctx, cancel := context.WithTimeout(context.Background(), config.MirrorTimeout)
+	defer cancel()
+
+	ref, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return mirrorResult{err: err}
+	}
+	u := *base
+	u.Path, u.RawPath, u.RawQuery = ref.Path, ref.RawPath, ref.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return mirrorResult{err: err}
+	}
+	req.Header = header
+	req.Header.Set("X-LB-Mirror", "1")
+
+	start := time.Now()
+	resp, err := mirrorClient.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return mirrorResult{latency: latency, err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return mirrorResult{status: resp.StatusCode, latency: latency}
+}
+
+// compareMirror waits for the shadow result and records how it differs from the primary one.
+// It's meant to run in its own goroutine, after the client has been served.
+func compareMirror(shadow <-chan mirrorResult, method, path string, status int, latency time.Duration) {
+	res := <-shadow
+	if res.err != nil {
+		atomic.AddInt64(&config.Mirror.Failed, 1)
+		log.Printf("mirror: %s %s failed on shadow: %v", method, path, res.err)
+		return
+	}
+
+	atomic.AddInt64(&config.Mirror.Compared, 1)
+	atomic.AddInt64(&config.Mirror.LatencyDiffNs, int64(res.latency-latency))
+
+	if res.status != status {
+		atomic.AddInt64(&config.Mirror.StatusMismatch, 1)
+		log.Printf("mirror: %s %s status mismatch primary=%d shadow=%d (latency primary=%v shadow=%v)",
+			method, path, status, res.status, latency, res.latency)
+	}
+}
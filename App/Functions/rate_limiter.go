@@ -0,0 +1,305 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// rateLimitMu guards rateLimitRules and rateLimitBuckets, the requests mostly read them:
+	// the tokens themselves are guarded by the mutex of their bucket
+	rateLimitMu sync.RWMutex
+
+	// rateLimitRules is the rule set currently enforced
+	rateLimitRules []structers.RateLimitRule
+
+	// rateLimitBuckets holds one bucket per rule name + key value
+	rateLimitBuckets = map[string]*structers.TokenBucket{}
+)
+
+func init() {
+	metrics.Describe("lb_ratelimit_allowed_total", metrics.Counter, "Requests allowed by a rate limit rule, counted for every rule they matched.")
+	metrics.Describe("lb_ratelimit_limited_total", metrics.Counter, "Requests rejected with 429 by a rate limit rule.")
+	metrics.Describe("lb_ratelimit_rules", metrics.Gauge, "Number of rate limit rules loaded.")
+}
+
+// WatchRateLimits loads the rules from config.RateLimitPath and reloads them every
+// time the file changes, it also evicts the idle buckets. Meant to run in its own goroutine.
+func WatchRateLimits() {
+	var lastMod time.Time
+	reload := func() {
+		info, err := os.Stat(config.RateLimitPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			if !lastMod.IsZero() {
+				log.Printf("rate limit file %s removed, limits disabled", config.RateLimitPath)
+				setRateLimitRules(nil)
+				lastMod = time.Time{}
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("rate limit stat: %v", err)
+			return
+		}
+		if info.ModTime().Equal(lastMod) {
+			return
+		}
+
+		rules, err := loadRateLimitRules(config.RateLimitPath)
+		if err != nil {
+			// keep enforcing the previous rules, a typo must not open the gates
+			log.Printf("rate limit reload failed, keeping previous rules: %v", err)
+			return
+		}
+		lastMod = info.ModTime()
+		setRateLimitRules(rules)
+		log.Printf("rate limit rules loaded: %d", len(rules))
+	}
+
+	reload()
+	ticker := time.NewTicker(config.RateLimitReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reload()
+		evictIdleBuckets()
+	}
+}
+
+// loadRateLimitRules reads and validates the rules file.
+func loadRateLimitRules(path string) ([]structers.RateLimitRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rate limits: %w", err)
+	}
+
+	var file struct {
+		Rules []structers.RateLimitRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode rate limits: %w", err)
+	}
+
+	names := make(map[string]bool, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: missing name", i)
+		}
+		// the buckets are keyed by rule name
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Rate <= 0 || rule.Burst <= 0 {
+			return nil, fmt.Errorf("rule %q: rate and burst must be positive", rule.Name)
+		}
+		switch {
+		case rule.Key == "ip", rule.Key == "apikey", rule.Key == "route":
+		case strings.HasPrefix(rule.Key, "header:") && len(rule.Key) > len("header:"):
+		default:
+			return nil, fmt.Errorf("rule %q: unknown key %q", rule.Name, rule.Key)
+		}
+	}
+	return file.Rules, nil
+}
+
+// setRateLimitRules swaps the enforced rules, the buckets are reset since their
+// rate or burst may have changed.
+func setRateLimitRules(rules []structers.RateLimitRule) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	rateLimitRules = rules
+	rateLimitBuckets = map[string]*structers.TokenBucket{}
+	metrics.Set("lb_ratelimit_rules", float64(len(rules)))
+}
+
+func evictIdleBuckets() {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	for key, bucket := range rateLimitBuckets {
+		bucket.Mutex.Lock()
+		idle := time.Since(bucket.Last) > config.RateLimitBucketTTL
+		bucket.Mutex.Unlock()
+		if idle {
+			delete(rateLimitBuckets, key)
+		}
+	}
+}
+
+// rateLimitKey extracts the value the rule buckets are keyed by, ok is false when
+// the request doesn't carry it (e.g. no API key), in which case the rule doesn't apply.
+func rateLimitKey(rule structers.RateLimitRule, r *http.Request) (string, bool) {
+	switch {
+	case rule.Key == "ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return host, true
+	case rule.Key == "apikey":
+		v := r.Header.Get(config.APIKeyHeader)
+		return v, v != ""
+	case rule.Key == "route":
+		return rule.Route, true
+	default:
+		v := r.Header.Get(strings.TrimPrefix(rule.Key, "header:"))
+		return v, v != ""
+	}
+}
+
+// rateLimitDecision is the outcome of one rule for one request.
+type rateLimitDecision struct {
+	rule      structers.RateLimitRule
+	allowed   bool
+	remaining int
+	reset     time.Duration // until the bucket is full again
+	retry     time.Duration // until one token is available, only set when denied
+}
+
+// rateLimitBucket returns the bucket of a rule + key value, creating it on first use.
+func rateLimitBucket(bucketKey string) *structers.TokenBucket {
+	rateLimitMu.RLock()
+	bucket, ok := rateLimitBuckets[bucketKey]
+	rateLimitMu.RUnlock()
+	if ok {
+		return bucket
+	}
+
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	// another request may have created it meanwhile
+	if bucket, ok := rateLimitBuckets[bucketKey]; ok {
+		return bucket
+	}
+	bucket = &structers.TokenBucket{}
+	rateLimitBuckets[bucketKey] = bucket
+	return bucket
+}
+
+// checkToken refills the bucket for the elapsed time and tells whether it has a token
+// for the request, without taking it: the caller does once every matching rule allows it.
+// The remaining count is the one left after the take. bucket.Mutex must be held.
+func checkToken(bucket *structers.TokenBucket, rule structers.RateLimitRule, now time.Time) rateLimitDecision {
+	burst := float64(rule.Burst)
+	if bucket.Last.IsZero() {
+		bucket.Tokens = burst
+	} else {
+		bucket.Tokens = math.Min(burst, bucket.Tokens+now.Sub(bucket.Last).Seconds()*rule.Rate)
+	}
+	bucket.Last = now
+
+	d := rateLimitDecision{rule: rule}
+	left := bucket.Tokens
+	if left >= 1 {
+		left--
+		d.allowed = true
+	} else {
+		d.retry = time.Duration((1 - left) / rule.Rate * float64(time.Second))
+	}
+	d.remaining = int(left)
+	d.reset = time.Duration((burst - left) / rule.Rate * float64(time.Second))
+	return d
+}
+
+// RateLimit wraps next with the token bucket rules, a request is rejected with
+// 429 as soon as one of the rules matching it is out of tokens. The tokens are only
+// taken when every matching rule allows the request, so a client throttled by one rule
+// doesn't drain its budget in the others.
+func RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMu.RLock()
+		rules := rateLimitRules
+		rateLimitMu.RUnlock()
+
+		type match struct {
+			key    string
+			rule   structers.RateLimitRule
+			bucket *structers.TokenBucket
+		}
+		var matches []match
+		for _, rule := range rules {
+			if rule.Route != "" && !strings.HasPrefix(r.URL.Path, rule.Route) {
+				continue
+			}
+			key, ok := rateLimitKey(rule, r)
+			if !ok {
+				continue
+			}
+			bucketKey := rule.Name + "|" + key
+			matches = append(matches, match{key: bucketKey, rule: rule, bucket: rateLimitBucket(bucketKey)})
+		}
+		if len(matches) == 0 {
+			next(w, r)
+			return
+		}
+
+		// the buckets of a request are checked and taken together, locked in the same
+		// order by every request so two of them can't wait on each other
+		slices.SortFunc(matches, func(a, b match) int { return strings.Compare(a.key, b.key) })
+		for _, m := range matches {
+			m.bucket.Mutex.Lock()
+		}
+		now := time.Now()
+		decisions := make([]rateLimitDecision, len(matches))
+		allowed := true
+		for i, m := range matches {
+			decisions[i] = checkToken(m.bucket, m.rule, now)
+			allowed = allowed && decisions[i].allowed
+		}
+		for _, m := range matches {
+			if allowed {
+				m.bucket.Tokens--
+			}
+			m.bucket.Mutex.Unlock()
+		}
+
+		// report the most restrictive rule, a denied one wins over any allowed one
+		worst := decisions[0]
+		for _, d := range decisions[1:] {
+			if (!d.allowed && worst.allowed) || (d.allowed == worst.allowed && d.remaining < worst.remaining) {
+				worst = d
+			}
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(worst.rule.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(worst.remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(worst.reset.Seconds()))))
+
+		if !allowed {
+			for _, d := range decisions {
+				if !d.allowed {
+					metrics.Inc("lb_ratelimit_limited_total", "rule", d.rule.Name)
+				}
+			}
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(worst.retry.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		for _, d := range decisions {
+			metrics.Inc("lb_ratelimit_allowed_total", "rule", d.rule.Name)
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,186 @@
+package functions
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// metricValue reads one series off the metrics endpoint, 0 when it's not there.
+// series is the name with its rendered labels, e.g. `lb_x_total{rule="a"}`.
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), " ")
+		if ok && name == series {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("metric %s: %v", series, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestCheckTokenRefill(t *testing.T) {
+	rule := structers.RateLimitRule{Name: "refill", Key: "ip", Rate: 2, Burst: 2}
+	var bucket structers.TokenBucket
+	t0 := time.Now()
+
+	take := func(at time.Duration) rateLimitDecision {
+		d := checkToken(&bucket, rule, t0.Add(at))
+		if d.allowed {
+			bucket.Tokens--
+		}
+		return d
+	}
+
+	steps := []struct {
+		at        time.Duration
+		allowed   bool
+		remaining int
+		retry     time.Duration
+	}{
+		// a new bucket starts full
+		{0, true, 1, 0},
+		{0, true, 0, 0},
+		{0, false, 0, 500 * time.Millisecond},
+		// half a token back
+		{250 * time.Millisecond, false, 0, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0, 0},
+		// the refill stops at the burst
+		{time.Hour, true, 1, 0},
+	}
+	for _, s := range steps {
+		d := take(s.at)
+		if d.allowed != s.allowed || d.remaining != s.remaining {
+			t.Errorf("at %v: allowed=%v remaining=%d, want %v and %d", s.at, d.allowed, d.remaining, s.allowed, s.remaining)
+		}
+		if got := d.retry.Round(time.Millisecond); got != s.retry {
+			t.Errorf("at %v: retry=%v, want %v", s.at, got, s.retry)
+		}
+	}
+}
+
+// useRateLimits enforces rules for the test.
+func useRateLimits(t *testing.T, rules ...structers.RateLimitRule) http.HandlerFunc {
+	t.Helper()
+	setRateLimitRules(rules)
+	t.Cleanup(func() { setRateLimitRules(nil) })
+	return RateLimit(func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	h := useRateLimits(t, structers.RateLimitRule{Name: "burst", Key: "ip", Rate: 0.5, Burst: 3})
+
+	for i, want := range []string{"2", "1", "0"} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != want {
+			t.Fatalf("request %d: %d with %s remaining, want 200 with %s", i+1, rec.Code, rec.Header().Get("RateLimit-Remaining"), want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2 (a token every 2s)", got)
+	}
+
+	// another client has its own bucket
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.7:4000"
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("other client: %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitMultiRuleDenial(t *testing.T) {
+	counted := map[string]float64{
+		`lb_ratelimit_allowed_total{rule="multi-ip"}`:    2,
+		`lb_ratelimit_allowed_total{rule="multi-route"}`: 1,
+		`lb_ratelimit_limited_total{rule="multi-route"}`: 1,
+		`lb_ratelimit_limited_total{rule="multi-ip"}`:    0,
+	}
+	before := make(map[string]float64, len(counted))
+	for series := range counted {
+		before[series] = metricValue(t, series)
+	}
+
+	h := useRateLimits(t,
+		structers.RateLimitRule{Name: "multi-ip", Key: "ip", Rate: 0.001, Burst: 5},
+		structers.RateLimitRule{Name: "multi-route", Key: "route", Route: "/api", Rate: 0.001, Burst: 1},
+	)
+
+	do := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := do("/api/users"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: %d, want 200", rec.Code)
+	}
+	rec := do("/api/users")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: %d, want 429 from the route rule", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "1" {
+		t.Errorf("denied by the rule with limit %s, want the route one (1)", got)
+	}
+
+	// the denied request didn't take a token from the ip rule
+	rec = do("/health")
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "3" {
+		t.Errorf("request off the route: %d with %s remaining, want 200 with 3", rec.Code, rec.Header().Get("RateLimit-Remaining"))
+	}
+
+	for series, want := range counted {
+		if got := metricValue(t, series) - before[series]; got != want {
+			t.Errorf("%s went up by %v, want %v", series, got, want)
+		}
+	}
+}
+
+func TestLoadRateLimitRulesRejects(t *testing.T) {
+	tests := []struct {
+		name, rules, err string
+	}{
+		{"missing name", `[{"key": "ip", "rate": 1, "burst": 1}]`, "missing name"},
+		{"no rate", `[{"name": "a", "key": "ip", "burst": 1}]`, "must be positive"},
+		{"unknown key", `[{"name": "a", "key": "cookie", "rate": 1, "burst": 1}]`, "unknown key"},
+		{"empty header", `[{"name": "a", "key": "header:", "rate": 1, "burst": 1}]`, "unknown key"},
+		{"duplicate", `[{"name": "a", "key": "ip", "rate": 1, "burst": 1}, {"name": "a", "key": "route", "rate": 1, "burst": 1}]`, "duplicate name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ratelimits.json")
+			if err := os.WriteFile(path, []byte(`{"rules": `+tt.rules+`}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadRateLimitRules(path)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
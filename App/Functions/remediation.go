@@ -0,0 +1,196 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// remediationMu guards replaceFailures and crashLooping
+	remediationMu sync.Mutex
+
+	// replaceFailures counts the replacements in a row that never became healthy
+	replaceFailures int
+
+	// crashLooping is set once replaceFailures reached CrashLoopThreshold,
+	// no replacement is made until it's reset
+	crashLooping bool
+
+	// remediationTrigger wakes the remediation loop up before its next tick
+	remediationTrigger = make(chan struct{}, 1)
+)
+
+func init() {
+	metrics.GaugeFunc("lb_pool_crashlooping", "1 when the pool is flagged as crash-looping and replacements are stopped.", func() float64 {
+		if CrashLooping() {
+			return 1
+		}
+		return 0
+	})
+	metrics.Describe("lb_replicas_replaced_total", metrics.Counter, "Dead replicas closed and replaced.")
+	metrics.Describe("lb_replacements_failed_total", metrics.Counter, "Replacements that failed to start or to become healthy.")
+}
+
+// CrashLooping reports whether replacements are stopped because they kept failing.
+func CrashLooping() bool {
+	remediationMu.Lock()
+	defer remediationMu.Unlock()
+	return crashLooping
+}
+
+// ResetCrashLoop clears the crash-loop flag so the remediation loop replaces dead replicas again.
+func ResetCrashLoop() {
+	remediationMu.Lock()
+	defer remediationMu.Unlock()
+	if crashLooping {
+		log.Printf("remediation: crash-loop flag reset, replacements resumed")
+	}
+	crashLooping = false
+	replaceFailures = 0
+}
+
+// StartRemediation periodically replaces the replicas dead for longer than ReplaceDeadAfter
+// (right away when their container exited). The replicas still starting are left to their startup probe.
+func StartRemediation() {
+	ticker := time.NewTicker(config.RemediationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-remediationTrigger:
+		}
+		if CrashLooping() {
+			// flagged already, leave the dead replicas for the operator to look at
+			continue
+		}
+		if DependencyDegraded() {
+			// the replicas are dead because of the dependency, new ones would be too
+			continue
+		}
+		for _, b := range replicasToReplace() {
+			replaceReplica(b)
+		}
+	}
+}
+
+// triggerRemediation asks for a remediation pass right away (non-blocking).
+func triggerRemediation() {
+	select {
+	case remediationTrigger <- struct{}{}:
+	default:
+		// a pass is already pending
+	}
+}
+
+// replicasToReplace collects the dead backends.
+func replicasToReplace() []*structers.Backend {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	var out []*structers.Backend
+	for _, b := range config.Unhealthy {
+		if atomic.LoadInt32(&b.ShuttingDown) == 1 || b.Starting {
+			continue
+		}
+		switch {
+		case b.Exited:
+			// the container itself is gone, no point in waiting for it
+			out = append(out, b)
+		case !b.Alive && !b.DeadSince.IsZero() && time.Since(b.DeadSince) >= config.ReplaceDeadAfter:
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// replaceReplica closes the given backend and starts a new one in its place,
+// unless the pool is crash-looping. A dry-run pool only reports it.
+func replaceReplica(b *structers.Backend) {
+	if DryRunEnabled(b.Pool) {
+		dryRunReplacement(b)
+		return
+	}
+
+	scalingMutex.Lock()
+	defer scalingMutex.Unlock()
+
+	if CrashLooping() {
+		return
+	}
+
+	start := time.Now()
+	// take it out of every list first so nothing routes to it or re-files it
+	atomic.StoreInt32(&b.ShuttingDown, 1)
+	config.BackendsMu.Lock()
+	removeFromUnHealthy(b)
+	if inHeap(b) {
+		heap.Remove(&config.Backends, b.HeapIdx)
+	}
+	noteTransition(b, SourceScaling, probeResult{})
+	config.BackendsMu.Unlock()
+
+	if _, err := CloseReplicas(b.ContainerID); err != nil && !strings.Contains(err.Error(), "No such container") {
+		log.Printf("remediation: closing %s failed: %v", b.ContainerID, err)
+	}
+
+	nb, err := startPendingReplica(true)
+	if err != nil {
+		recordReplacementFailure(b, err.Error())
+		return
+	}
+
+	metrics.Inc("lb_replicas_replaced_total")
+	n := replicaCount()
+	publishEvent(structers.Event{
+		Type:        EventReplicaReplaced,
+		Pool:        b.Pool,
+		Reason:      fmt.Sprintf("replaced by %s", nb.ContainerID),
+		ContainerID: b.ContainerID,
+		Before:      n,
+		After:       n,
+		Duration:    time.Since(start),
+	})
+}
+
+// recordReplacementFailure counts a failed replacement and flags the pool as
+// crash-looping once CrashLoopThreshold is reached.
+func recordReplacementFailure(b *structers.Backend, reason string) {
+	metrics.Inc("lb_replacements_failed_total")
+	emitEvent(EventReplicaFailed, b.Pool, reason, b.ContainerID)
+
+	remediationMu.Lock()
+	replaceFailures++
+	failures := replaceFailures
+	flagged := failures >= config.CrashLoopThreshold && !crashLooping
+	if flagged {
+		crashLooping = true
+	}
+	remediationMu.Unlock()
+
+	if flagged {
+		emitEvent(EventPoolCrashLoop, b.Pool,
+			fmt.Sprintf("%d replacements failed in a row, replacements stopped", failures), "")
+	}
+}
+
+// replacementHealthy resets the failure streak once a replacement passed its health check.
+func replacementHealthy(b *structers.Backend) {
+	remediationMu.Lock()
+	defer remediationMu.Unlock()
+	if replaceFailures > 0 {
+		log.Printf("remediation: replacement %s is healthy, failure streak reset", b.ContainerID)
+	}
+	replaceFailures = 0
+}
@@ -0,0 +1,69 @@
+package functions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+)
+
+func TestCrashedReplicaIsReplaced(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+
+	res := ScaleUpN(1)
+	if len(res.Succeeded) != 1 {
+		t.Fatalf("ScaleUpN(1) = %+v", res)
+	}
+	old := res.Succeeded[0]
+	waitFor(t, 5*time.Second, "the replica to get ready", func() bool { return readyCount() == 1 })
+
+	// what WatchDockerEvents subscribes to
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, _ := rt.Events(ctx, map[string]string{"com.docker.compose.service": config.ParentName})
+
+	if err := rt.Crash(old, ActionOOM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.ID != old || msg.Action != ActionOOM {
+			t.Fatalf("event = %+v, want %s of %s", msg, ActionOOM, old)
+		}
+		handleDockerEvent(msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the crashed replica")
+	}
+	if got := readyCount(); got != 0 {
+		t.Fatalf("%d replicas in rotation after the crash, want 0", got)
+	}
+
+	// one pass of StartRemediation
+	dead := replicasToReplace()
+	if len(dead) != 1 || dead[0].ContainerID != old {
+		t.Fatalf("replicas to replace = %v, want %s", dead, old)
+	}
+	replaceReplica(dead[0])
+
+	waitFor(t, 5*time.Second, "the replacement to get ready", func() bool { return readyCount() == 1 })
+	if findBackend(old) != nil {
+		t.Errorf("crashed backend %s still filed", old)
+	}
+	if _, err := rt.Inspect(context.Background(), old); err == nil {
+		t.Errorf("crashed container %s not removed", old)
+	}
+	if CrashLooping() {
+		t.Error("pool flagged as crash-looping after a successful replacement")
+	}
+
+	replaced := false
+	for _, ev := range recentEvents() {
+		if ev.Type == EventReplicaReplaced && ev.ContainerID == old {
+			replaced = true
+		}
+	}
+	if !replaced {
+		t.Errorf("no %s event for %s", EventReplicaReplaced, old)
+	}
+}
@@ -3,120 +3,75 @@
 package functions
 
 import (
-	"container/heap"
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/url"
-	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"encoding/json"
 
 	composeLoader "github.com/compose-spec/compose-go/loader"
 	composeTypes "github.com/compose-spec/compose-go/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
-	"github.com/docker/go-connections/nat"
 
 	"github.com/xaydras-2/loadBalancer/App/config"
 	"github.com/xaydras-2/loadBalancer/App/structers"
 )
 
 // CreateReplicas spins up one new container instance of your API, listening
-// on the given hostPort, and returns a Backend pointing to it.
-func CreateReplicas(imageName string, containerPort string, networkName string) (*structers.Backend, error) {
+// on the given hostPort, and returns a Backend pointing to it. On failure no container
+// is left behind.
+func CreateReplicas(imageName string, containerPort string, networkName string) (_ *structers.Backend, err error) {
 	ctx := context.Background()
+	rt := replicaRuntime
 
-	// Create Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	nextIndex, err := allocateAPISuffix(rt, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("docker client init: %w", err)
+		return nil, fmt.Errorf("could not compute next suffix: %w", err)
 	}
-	defer cli.Close()
-
-	// Pull image if missing
-	imgs, err := cli.ImageList(ctx, image.ListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.Arg("reference", imageName)),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("image list: %w", err)
-	}
-	if len(imgs) == 0 {
-		out, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("image pull: %w", err)
+			releaseAPISuffix(nextIndex)
 		}
-		io.Copy(os.Stdout, out)
-		out.Close()
-	}
-
-	// Set up port mapping
-	portKey := nat.Port(containerPort + "/tcp")
-	exposed := nat.PortSet{portKey: struct{}{}}
-	bindings := nat.PortMap{portKey: []nat.PortBinding{
-		{HostIP: "0.0.0.0", HostPort: ""},
-	}}
-
-	nextIndex, err := NextAPISuffix(cli, ctx, "api", config.ParentName)
-	if err != nil {
-		log.Fatalf("could not compute next suffix: %v", err)
-	}
+	}()
 
 	containerName := fmt.Sprintf("%s-%d", config.ParentName, nextIndex)
 
 	// Create the container
-	resp, err := cli.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image:        imageName,
-			Cmd:          []string{"--port", containerPort},
-			ExposedPorts: exposed,
-			Env: []string{
-				"DB_HOST=postgres_db",
-				"DB_PORT=5432",
-				"DB_USER=postgres",
-				"DB_PASSWORD=postgres2025",
-				"DB_NAME=test_lb",
-			},
-			Labels: map[string]string{
-				"com.docker.compose.project": "api",
-				"com.docker.compose.service": config.SvcTemp.Name,
-			},
-		},
-		&container.HostConfig{
-			PortBindings: bindings,
+	containerID, err := rt.Create(ctx, structers.ReplicaSpec{
+		Name:  containerName,
+		Image: imageName,
+		Cmd:   []string{"--port", containerPort},
+		Env: []string{
+			"DB_HOST=postgres_db",
+			"DB_PORT=5432",
+			"DB_USER=postgres",
+			"DB_PASSWORD=postgres2025",
+			"DB_NAME=test_lb",
 		},
-		&network.NetworkingConfig{
-			EndpointsConfig: map[string]*network.EndpointSettings{
-				networkName: {},
-			},
+		Labels: map[string]string{
+			"com.docker.compose.project": "api",
+			// what NextAPISuffix and the events watcher look for
+			"com.docker.compose.service": config.ParentName,
 		},
-		nil,           // *specs.Platform
-		containerName, // container name
-	)
+		Port:    containerPort,
+		Network: networkName,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("container create: %w", err)
 	}
 
 	// Start the container
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return nil, fmt.Errorf("container start: %w", err)
+	if err := rt.Start(ctx, containerID); err != nil {
+		return nil, discardReplica(rt, containerID, fmt.Errorf("container start: %w", err))
 	}
 
-	containerID := resp.ID
-
-	portExtKey := nat.Port(containerPort + "/tcp")
 	var hostPort string
 
 	const (
@@ -124,24 +79,26 @@ func CreateReplicas(imageName string, containerPort string, networkName string)
 		sleepMs    = 100
 	)
 	for i := 0; i < maxRetries; i++ {
-		insp, err := cli.ContainerInspect(ctx, resp.ID)
+		insp, err := rt.Inspect(ctx, containerID)
 		if err != nil {
-			return nil, fmt.Errorf("inspect container (attempt %d): %w", i+1, err)
+			return nil, discardReplica(rt, containerID, fmt.Errorf("inspect container (attempt %d): %w", i+1, err))
 		}
-		bindings := insp.NetworkSettings.Ports[portExtKey]
-		if len(bindings) > 0 && bindings[0].HostPort != "" {
-			hostPort = bindings[0].HostPort
+		if p := insp.Ports[containerPort]; p != "" {
+			hostPort = p
 			break
 		}
 		time.Sleep(sleepMs * time.Millisecond)
 	}
+	if hostPort == "" {
+		return nil, discardReplica(rt, containerID, fmt.Errorf("no host port published for %s after %d attempts", containerPort, maxRetries))
+	}
 
 	// Build the Backend struct pointing at our new instance
 	urlStr := fmt.Sprintf("http://localhost:%s", hostPort)
 	parsed, err := url.Parse(urlStr)
 
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL %q: %w", urlStr, err)
+		return nil, discardReplica(rt, containerID, fmt.Errorf("invalid URL %q: %w", urlStr, err))
 	}
 
 	backend := &structers.Backend{
@@ -149,20 +106,62 @@ func CreateReplicas(imageName string, containerPort string, networkName string)
 		Alive:       true, // default to true, health will be checked by the LoadBalancer
 		ContainerID: containerID,
 		StartTime:   time.Now(),
+		Pool:        config.ParentName,
 	}
 
 	return backend, nil
 }
 
+// discardReplica stops and removes a container CreateReplicas couldn't turn into a backend,
+// nothing would count it against MaxReplicas otherwise. It returns err, the cleanup failure
+// appended if any.
+func discardReplica(rt ReplicaRuntime, containerID string, err error) error {
+	ctx := context.Background()
+	// a container that never started has nothing to stop
+	stopErr := rt.Stop(ctx, containerID)
+	if rmErr := rt.Remove(ctx, containerID); rmErr != nil {
+		log.Printf("discarding container %s: stop: %v, remove: %v", containerID, stopErr, rmErr)
+		return fmt.Errorf("%w (container %s left behind: %v)", err, containerID, rmErr)
+	}
+	return err
+}
+
+var (
+	// suffixMu guards lastSuffix, the highest <parentName>-<N> suffix handed out
+	suffixMu   sync.Mutex
+	lastSuffix int
+)
+
+// allocateAPISuffix hands out the suffix of a new container name, unique even when
+// several containers are created at the same time (their names only show up in the
+// container list once created).
+func allocateAPISuffix(rt ReplicaRuntime, ctx context.Context) (int, error) {
+	suffixMu.Lock()
+	defer suffixMu.Unlock()
+
+	next, err := NextAPISuffix(rt, ctx, config.ParentName, config.ParentName)
+	if err != nil {
+		return 0, err
+	}
+	lastSuffix = max(next, lastSuffix+1)
+	return lastSuffix, nil
+}
+
+// releaseAPISuffix gives back the suffix of a container that couldn't be created,
+// if no later one was handed out meanwhile.
+func releaseAPISuffix(n int) {
+	suffixMu.Lock()
+	defer suffixMu.Unlock()
+	if lastSuffix == n {
+		lastSuffix--
+	}
+}
+
 // NextAPISuffix it extract the name of the container, and gets the number of the last created one.
 // Note: it needs the container to follow this formate <parentName>-<N> (Docker compose/ microservice)
-func NextAPISuffix(cli *client.Client, ctx context.Context, serviceName, parentName string) (int, error) {
-	// 1) List all running containers with label com.docker.compose.service=api
-	args := filters.NewArgs(
-		filters.Arg("label", "com.docker.compose.service="+serviceName),
-	)
-	listOpts := container.ListOptions{All: true, Filters: args}
-	containers, err := cli.ContainerList(ctx, listOpts)
+func NextAPISuffix(rt ReplicaRuntime, ctx context.Context, serviceName, parentName string) (int, error) {
+	// 1) List all the containers with label com.docker.compose.service=api
+	containers, err := rt.List(ctx, map[string]string{"com.docker.compose.service": serviceName}, true)
 	if err != nil {
 		return 0, fmt.Errorf("listing api containers: %w", err)
 	}
@@ -172,13 +171,9 @@ func NextAPISuffix(cli *client.Client, ctx context.Context, serviceName, parentN
 	re := regexp.MustCompile(fmt.Sprintf(`^%s-(\d+)$`, regexp.QuoteMeta(parentName)))
 	var nums []int
 	for _, c := range containers {
-		for _, name := range c.Names {
-			// Docker returns names prefixed with '/', so strip it off
-			n := strings.TrimPrefix(name, "/")
-			if matches := re.FindStringSubmatch(n); matches != nil {
-				if i, err := strconv.Atoi(matches[1]); err == nil {
-					nums = append(nums, i)
-				}
+		if matches := re.FindStringSubmatch(c.Name); matches != nil {
+			if i, err := strconv.Atoi(matches[1]); err == nil {
+				nums = append(nums, i)
 			}
 		}
 	}
@@ -196,19 +191,13 @@ func NextAPISuffix(cli *client.Client, ctx context.Context, serviceName, parentN
 func CloseReplicas(containerID string) (string, error) {
 	ctx := context.Background()
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return "", fmt.Errorf("docker client init: %w", err)
-	}
-	defer cli.Close()
-
 	// 1. Stop the container
-	if err := cli.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
+	if err := replicaRuntime.Stop(ctx, containerID); err != nil {
 		return "", fmt.Errorf("failed to stop container %q: %w", containerID, err)
 	}
 
 	// 2. Remove the container after stopping it
-	if err := cli.ContainerRemove(ctx, containerID, container.RemoveOptions{}); err != nil {
+	if err := replicaRuntime.Remove(ctx, containerID); err != nil {
 		return "", fmt.Errorf("failed to remove container %q: %w", containerID, err)
 	}
 
@@ -217,55 +206,79 @@ func CloseReplicas(containerID string) (string, error) {
 
 // gets the cpu and memory of a given container id
 func GetInfoAboutReplica(containerID string) (*structers.ReplicaStats, error) {
-	ctx := context.Background()
-
-	// initialize Docker client
-	cli, err := client.NewClientWithOpts(
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("docker client init: %w", err)
-	}
-	defer cli.Close()
-
 	// fetch one-shot stats (non-streaming)
-	statsRes, err := cli.ContainerStats(ctx, containerID, false)
+	body, err := replicaRuntime.Stats(context.Background(), containerID, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get stats: %w", err)
 	}
-	defer statsRes.Body.Close()
+	defer body.Close()
 
 	// decode into StatsJSON
 	var s structers.StatsJSON
-	if err := json.NewDecoder(statsRes.Body).Decode(&s); err != nil {
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
 		return nil, fmt.Errorf("failed to decode stats: %w", err)
 	}
 
+	return replicaStatsFrom(&s), nil
+}
+
+// replicaStatsFrom computes the usage of a container from one Docker stats sample, the same
+// way `docker stats` does so it's right on both cgroup v1 and v2 hosts.
+func replicaStatsFrom(s *structers.StatsJSON) *structers.ReplicaStats {
+	rs := &structers.ReplicaStats{Read: s.Read}
+
 	// calculate CPU %
-	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage - s.PreCPUStats.CPUUsage.TotalUsage)
-	systemDelta := float64(s.CPUStats.SystemUsage - s.PreCPUStats.SystemUsage)
-	cpuPercent := 0.0
+	// percpu_usage isn't reported on cgroup v2, online_cpus is on both
+	rs.OnlineCPUs = s.CPUStats.OnlineCPUs
+	if rs.OnlineCPUs == 0 {
+		rs.OnlineCPUs = uint32(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
 	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuCount := float64(len(s.CPUStats.CPUUsage.PercpuUsage))
-		cpuPercent = (cpuDelta / systemDelta) * cpuCount * 100.0
+		rs.CPUPercent = (cpuDelta / systemDelta) * float64(rs.OnlineCPUs) * 100.0
+	}
+
+	throttling, prev := s.CPUStats.ThrottlingData, s.PreCPUStats.ThrottlingData
+	rs.ThrottledTime = throttling.ThrottledTime
+	if periods := throttling.Periods - prev.Periods; throttling.Periods > prev.Periods {
+		rs.ThrottledPercent = float64(throttling.ThrottledPeriods-prev.ThrottledPeriods) / float64(periods) * 100.0
 	}
 
 	// calculate Memory %
-	// subtract cache to get the real cache
-	memUsage := s.MemoryStats.Usage - s.MemoryStats.Stats["cache"]
-	memLimit := s.MemoryStats.Limit
-	memPercent := 0.0
-	if memLimit > 0 {
-		memPercent = (float64(memUsage) / float64(memLimit)) * 100.0
-	}
-
-	return &structers.ReplicaStats{
-		CPUPercent:    cpuPercent,
-		MemoryUsage:   memUsage,
-		MemoryLimit:   memLimit,
-		MemoryPercent: memPercent,
-	}, nil
+	// the page cache is reclaimable, leave its inactive part out:
+	// total_inactive_file on cgroup v1, inactive_file on cgroup v2 ("cache" is v1 only)
+	rs.MemoryUsage = s.MemoryStats.Usage
+	if v, ok := s.MemoryStats.Stats["total_inactive_file"]; ok && v < rs.MemoryUsage {
+		rs.MemoryUsage -= v
+	} else if v, ok := s.MemoryStats.Stats["inactive_file"]; ok && v < rs.MemoryUsage {
+		rs.MemoryUsage -= v
+	}
+	rs.MemoryLimit = s.MemoryStats.Limit
+	if rs.MemoryLimit > 0 {
+		rs.MemoryPercent = (float64(rs.MemoryUsage) / float64(rs.MemoryLimit)) * 100.0
+	}
+
+	for _, n := range s.Networks {
+		rs.NetRxBytes += n.RxBytes
+		rs.NetTxBytes += n.TxBytes
+		rs.NetRxDropped += n.RxDropped
+		rs.NetTxDropped += n.TxDropped
+	}
+
+	// "Read"/"Write" on cgroup v1, "read"/"write" on cgroup v2
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			rs.BlockReadBytes += e.Value
+		case "write":
+			rs.BlockWriteBytes += e.Value
+		}
+	}
+
+	rs.PIDs = s.PidsStats.Current
+	rs.PIDsLimit = s.PidsStats.Limit
+	return rs
 }
 
 // CallContainers loads the containers of the returned project by loadComposeFile.
@@ -276,16 +289,10 @@ func CallContainers() {
 		log.Fatalf("could not load compose: %v", err)
 	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Fatalf("docker client: %v", err)
-	}
-
-	defer cli.Close()
 	ctx := context.Background()
 
 	// Create networks first
-	if err := createNetworks(cli, ctx, project); err != nil {
+	if err := createNetworks(ctx, project); err != nil {
 		log.Fatalf("failed to create networks: %v", err)
 	}
 
@@ -303,7 +310,7 @@ func CallContainers() {
 
 		case "postgres":
 			// Ensure exactly 1 replica of db
-			if err := ensureDB(cli, ctx, svc, primaryNetwork); err != nil {
+			if err := ensureDB(ctx, svc, primaryNetwork); err != nil {
 				log.Fatalf("db error: %v", err)
 			}
 
@@ -318,10 +325,10 @@ func CallContainers() {
 					log.Fatalf("create api replica: %v", err)
 				}
 				fmt.Printf("started API backend: %+v\n", backend)
-				// add to the heap
-				config.BackendsMu.Lock()
-				heap.Push(&config.Backends, backend)
-				config.BackendsMu.Unlock()
+				// it joins the heap once its startup probe passes
+				if err := registerStartingReplica(backend, false, 0); err != nil {
+					log.Fatalf("register api replica: %v", err)
+				}
 			}
 
 		default:
@@ -331,15 +338,8 @@ func CallContainers() {
 }
 
 // ensureDB makes sure there is exactly one container for the db service
-func ensureDB(cli *client.Client, ctx context.Context, svc composeTypes.ServiceConfig, networkName string) error {
-	filters := filters.NewArgs(
-		filters.Arg("label", "com.docker.compose.service="+svc.Name),
-		filters.Arg("status", "running"),
-	)
-	existing, err := cli.ContainerList(ctx, container.ListOptions{
-		All:     false,
-		Filters: filters,
-	})
+func ensureDB(ctx context.Context, svc composeTypes.ServiceConfig, networkName string) error {
+	existing, err := replicaRuntime.List(ctx, map[string]string{"com.docker.compose.service": svc.Name}, false)
 	if err != nil {
 		return fmt.Errorf("list db containers: %w", err)
 	}
@@ -350,10 +350,6 @@ func ensureDB(cli *client.Client, ctx context.Context, svc composeTypes.ServiceC
 
 	// otherwise, create & start one
 	portDef := svc.Ports[0]
-	portKey := nat.Port(fmt.Sprintf("%d/tcp", portDef.Target))
-	binds := nat.PortMap{
-		portKey: []nat.PortBinding{{HostPort: portDef.Published}},
-	}
 
 	// Get environment variables from service config
 	env := getServiceEnvironment(svc)
@@ -367,35 +363,26 @@ func ensureDB(cli *client.Client, ctx context.Context, svc composeTypes.ServiceC
 		}
 	}
 
-	resp, err := cli.ContainerCreate(ctx,
-		&container.Config{
-			Image:        svc.Image,
-			ExposedPorts: nat.PortSet{portKey: struct{}{}},
-			Env:          env,
-			Labels: map[string]string{
-				"com.docker.compose.project": "api",
-				"com.docker.compose.service": svc.Name,
-			},
+	id, err := replicaRuntime.Create(ctx, structers.ReplicaSpec{
+		Name:  svc.ContainerName,
+		Image: svc.Image,
+		Env:   env,
+		Labels: map[string]string{
+			"com.docker.compose.project": "api",
+			"com.docker.compose.service": svc.Name,
 		},
-		&container.HostConfig{
-			PortBindings: binds,
-		},
-		&network.NetworkingConfig{
-			EndpointsConfig: map[string]*network.EndpointSettings{
-				networkName: {},
-			},
-		},
-		nil,
-		svc.ContainerName,
-	)
+		Port:     strconv.Itoa(int(portDef.Target)),
+		HostPort: portDef.Published,
+		Network:  networkName,
+	})
 	if err != nil {
 		return fmt.Errorf("create db container: %w", err)
 	}
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+	if err := replicaRuntime.Start(ctx, id); err != nil {
 		return fmt.Errorf("start db container: %w", err)
 	}
 
-	log.Printf("Created and started database container: %s", resp.ID)
+	log.Printf("Created and started database container: %s", id)
 	return nil
 }
 
@@ -421,53 +408,25 @@ func loadComposeFile(path string) (*composeTypes.Project, error) {
 }
 
 // createNetworks creates all networks defined in the compose file
-func createNetworks(cli *client.Client, ctx context.Context, project *composeTypes.Project) error {
+func createNetworks(ctx context.Context, project *composeTypes.Project) error {
 	for networkName, networkConfig := range project.Networks {
-		// Check if network already exists
-		networks, err := cli.NetworkList(ctx, network.ListOptions{
-			Filters: filters.NewArgs(filters.Arg("name", networkName)),
-		})
-		if err != nil {
-			return fmt.Errorf("failed to list networks: %w", err)
-		}
-
 		if networkName == "default" {
 			log.Printf("Skipping predefined network %q", networkName)
 			continue
 		}
 
-		// Skip if network already exists
-		if len(networks) > 0 {
-			log.Printf("Network %s already exists, skipping creation", networkName)
-			continue
-		}
-
-		// Create network options
-		createOptions := network.CreateOptions{
-			Driver: "bridge", // default driver
+		err := replicaRuntime.EnsureNetwork(ctx, structers.NetworkSpec{
+			Name:    networkName,
+			Driver:  networkConfig.Driver,
+			Options: networkConfig.DriverOpts,
 			Labels: map[string]string{
 				"com.docker.compose.project": project.Name,
 				"com.docker.compose.network": networkName,
 			},
-		}
-
-		// Apply custom driver if specified
-		if networkConfig.Driver != "" {
-			createOptions.Driver = networkConfig.Driver
-		}
-
-		// Apply custom options if specified
-		if networkConfig.DriverOpts != nil {
-			createOptions.Options = networkConfig.DriverOpts
-		}
-
-		// Create the network
-		resp, err := cli.NetworkCreate(ctx, networkName, createOptions)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create network %s: %w", networkName, err)
+			return err
 		}
-
-		log.Printf("Created network: %s (ID: %s)", networkName, resp.ID)
 	}
 
 	return nil
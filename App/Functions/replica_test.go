@@ -0,0 +1,208 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// containerNames lists the containers of the runtime, stopped ones included.
+func containerNames(t *testing.T, rt *FakeRuntime) []string {
+	t.Helper()
+	list, err := rt.List(context.Background(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range list {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestCreateReplicasLeavesNoContainerBehind(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(rt *FakeRuntime)
+		err   string
+	}{
+		{
+			name:  "start fails",
+			setup: func(rt *FakeRuntime) { rt.StartHook = func(string) error { return errors.New("no space left") } },
+			err:   "container start: no space left",
+		},
+		{
+			name:  "no host port",
+			setup: func(rt *FakeRuntime) { rt.NoPorts = true },
+			err:   "no host port",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := useFakeRuntime(t, nil)
+			tt.setup(rt)
+
+			b, err := CreateReplicas(config.ImageName, config.ContainerPort, config.NetworkName)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("CreateReplicas() = %v, %v, want an error about %q", b, err, tt.err)
+			}
+			if names := containerNames(t, rt); len(names) != 0 {
+				t.Errorf("containers left behind: %v", names)
+			}
+
+			// the suffix of the failed one is handed out again
+			rt.StartHook, rt.NoPorts = nil, false
+			if _, err := CreateReplicas(config.ImageName, config.ContainerPort, config.NetworkName); err != nil {
+				t.Fatal(err)
+			}
+			if names := containerNames(t, rt); len(names) != 1 || names[0] != config.ParentName+"-1" {
+				t.Errorf("containers = %v, want [%s-1]", names, config.ParentName)
+			}
+		})
+	}
+}
+
+func TestScaleUpNPartialFailureKeepsMaxReplicas(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+	starts := 0
+	rt.StartHook = func(name string) error {
+		// the hook runs under the runtime's lock
+		starts++
+		if starts == 2 {
+			return errors.New("port is already allocated")
+		}
+		return nil
+	}
+
+	res := ScaleUpN(3)
+	if len(res.Succeeded) != 2 || len(res.Failed) != 1 {
+		t.Fatalf("ScaleUpN(3) = %+v, want 2 created and 1 failed", res)
+	}
+	waitFor(t, 5*time.Second, "the replicas to get ready", func() bool { return readyCount() == 2 })
+
+	// every container running is one the pool counts
+	names := containerNames(t, rt)
+	config.BackendsMu.Lock()
+	counted := containerCount()
+	config.BackendsMu.Unlock()
+	if len(names) != counted {
+		t.Errorf("%d containers (%v) but %d counted against MaxReplicas", len(names), names, counted)
+	}
+}
+
+// docker stats samples, trimmed to what replicaStatsFrom reads
+const (
+	// cgroup v1: percpu_usage and no online_cpus on older engines, total_inactive_file
+	statsCgroupV1 = `{
+		"read": "2026-03-10T12:00:01Z",
+		"cpu_stats": {
+			"cpu_usage": {"total_usage": 1400000000, "percpu_usage": [350000000, 350000000, 350000000, 350000000]},
+			"system_cpu_usage": 14000000000
+		},
+		"precpu_stats": {
+			"cpu_usage": {"total_usage": 1000000000, "percpu_usage": [250000000, 250000000, 250000000, 250000000]},
+			"system_cpu_usage": 10000000000
+		},
+		"memory_stats": {
+			"usage": 600000000,
+			"limit": 1000000000,
+			"stats": {"cache": 200000000, "total_inactive_file": 100000000, "inactive_file": 50000000}
+		},
+		"blkio_stats": {"io_service_bytes_recursive": [
+			{"major": 8, "minor": 0, "op": "Read", "value": 4096},
+			{"major": 8, "minor": 0, "op": "Write", "value": 8192},
+			{"major": 8, "minor": 0, "op": "Total", "value": 12288}
+		]}
+	}`
+
+	// cgroup v2: no percpu_usage, online_cpus, inactive_file only
+	statsCgroupV2 = `{
+		"read": "2026-03-10T12:00:01Z",
+		"cpu_stats": {
+			"cpu_usage": {"total_usage": 1300000000},
+			"system_cpu_usage": 12000000000,
+			"online_cpus": 2
+		},
+		"precpu_stats": {
+			"cpu_usage": {"total_usage": 1000000000},
+			"system_cpu_usage": 10000000000,
+			"online_cpus": 2
+		},
+		"memory_stats": {
+			"usage": 500000000,
+			"limit": 800000000,
+			"stats": {"anon": 300000000, "file": 200000000, "inactive_file": 100000000}
+		},
+		"blkio_stats": {"io_service_bytes_recursive": [
+			{"major": 8, "minor": 0, "op": "read", "value": 4096},
+			{"major": 8, "minor": 0, "op": "write", "value": 8192}
+		]}
+	}`
+)
+
+func TestReplicaStatsFromCgroupV1AndV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		sample  string
+		patch   func(*structers.StatsJSON)
+		cpus    uint32
+		cpu     float64
+		memory  uint64
+		percent float64
+	}{
+		// (1.4e9-1e9)/(14e9-10e9) * 4 cpus = 40%, (600M-100M)/1G = 50%
+		{name: "v1 counts the cpus from percpu_usage", sample: statsCgroupV1, cpus: 4, cpu: 40, memory: 500000000, percent: 50},
+		{
+			name: "v1 prefers online_cpus", sample: statsCgroupV1,
+			patch: func(s *structers.StatsJSON) { s.CPUStats.OnlineCPUs = 2 },
+			cpus:  2, cpu: 20, memory: 500000000, percent: 50,
+		},
+		{
+			name: "v1 without total_inactive_file", sample: statsCgroupV1,
+			patch: func(s *structers.StatsJSON) { delete(s.MemoryStats.Stats, "total_inactive_file") },
+			cpus:  4, cpu: 40, memory: 550000000, percent: 55,
+		},
+		// (1.3e9-1e9)/(12e9-10e9) * 2 cpus = 30%, (500M-100M)/800M = 50%
+		{name: "v2", sample: statsCgroupV2, cpus: 2, cpu: 30, memory: 400000000, percent: 50},
+		{
+			name: "v2 inactive_file past the usage is ignored", sample: statsCgroupV2,
+			patch: func(s *structers.StatsJSON) { s.MemoryStats.Stats["inactive_file"] = 600000000 },
+			cpus:  2, cpu: 30, memory: 500000000, percent: 62.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s structers.StatsJSON
+			if err := json.Unmarshal([]byte(tt.sample), &s); err != nil {
+				t.Fatal(err)
+			}
+			if tt.patch != nil {
+				tt.patch(&s)
+			}
+
+			rs := replicaStatsFrom(&s)
+			if rs.OnlineCPUs != tt.cpus {
+				t.Errorf("online cpus = %d, want %d", rs.OnlineCPUs, tt.cpus)
+			}
+			if math.Abs(rs.CPUPercent-tt.cpu) > 1e-9 {
+				t.Errorf("cpu = %.3f%%, want %.3f%%", rs.CPUPercent, tt.cpu)
+			}
+			if rs.MemoryUsage != tt.memory {
+				t.Errorf("memory usage = %d, want %d", rs.MemoryUsage, tt.memory)
+			}
+			if math.Abs(rs.MemoryPercent-tt.percent) > 1e-9 {
+				t.Errorf("memory = %.3f%%, want %.3f%%", rs.MemoryPercent, tt.percent)
+			}
+			if rs.BlockReadBytes != 4096 || rs.BlockWriteBytes != 8192 {
+				t.Errorf("block io = %d read, %d written, want 4096 and 8192", rs.BlockReadBytes, rs.BlockWriteBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"context"
+	"io"
+
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// Container states and lifecycle actions, as the runtimes report them (Docker's names)
+const (
+	ContainerRunning = "running"
+	ContainerCreated = "created"
+	ContainerExited  = "exited"
+
+	ActionDie             = "die"
+	ActionOOM             = "oom"
+	ActionKill            = "kill"
+	ActionDestroy         = "destroy"
+	ActionHealthStatus    = "health_status"
+	ActionHealthUnhealthy = "health_status: unhealthy"
+)
+
+// ReplicaRuntime runs the containers of the pool and of its dependencies. The containers
+// can be named by their ID or their name. DockerRuntime is the real one, the tests use
+// FakeRuntime, an in-memory one serving httptest backends.
+type ReplicaRuntime interface {
+	// Create creates a container from the spec, not started, and returns its ID.
+	Create(ctx context.Context, spec structers.ReplicaSpec) (string, error)
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string) error
+	// Remove deletes a stopped container.
+	Remove(ctx context.Context, id string) error
+
+	// Stats returns the usage samples of a container as a JSON stream of structers.StatsJSON,
+	// a single one unless stream is set. The caller closes it.
+	Stats(ctx context.Context, id string, stream bool) (io.ReadCloser, error)
+
+	// List returns the containers carrying all the given labels, the stopped ones too with all.
+	List(ctx context.Context, labels map[string]string, all bool) ([]structers.ContainerInfo, error)
+	Inspect(ctx context.Context, id string) (structers.ContainerInfo, error)
+
+	// Events streams the die, oom, kill, destroy and health_status events of the containers
+	// carrying the labels until ctx is done or the stream fails, the error channel tells which.
+	Events(ctx context.Context, labels map[string]string) (<-chan structers.ContainerEvent, <-chan error)
+
+	// EnsureNetwork creates the network if it doesn't exist yet.
+	EnsureNetwork(ctx context.Context, spec structers.NetworkSpec) error
+}
+
+// replicaRuntime is the runtime every container operation goes through.
+var replicaRuntime ReplicaRuntime = NewDockerRuntime()
+
+// SetRuntime replaces the runtime, e.g. by a FakeRuntime. It must be called before
+// anything creates or watches a container.
+func SetRuntime(rt ReplicaRuntime) {
+	replicaRuntime = rt
+}
@@ -0,0 +1,301 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	dockerEvents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// DockerRuntime runs the containers on the Docker daemon of the environment (DOCKER_HOST...).
+type DockerRuntime struct {
+	// the client is opened on first use and shared, it's safe for concurrent use
+	once sync.Once
+	cli  *client.Client
+	err  error
+}
+
+// NewDockerRuntime returns a runtime talking to the Docker daemon, connected on first use.
+func NewDockerRuntime() *DockerRuntime {
+	return &DockerRuntime{}
+}
+
+func (d *DockerRuntime) client() (*client.Client, error) {
+	d.once.Do(func() {
+		d.cli, d.err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if d.err != nil {
+			d.err = fmt.Errorf("docker client init: %w", d.err)
+		}
+	})
+	return d.cli, d.err
+}
+
+func (d *DockerRuntime) Create(ctx context.Context, spec structers.ReplicaSpec) (string, error) {
+	cli, err := d.client()
+	if err != nil {
+		return "", err
+	}
+
+	// Pull image if missing
+	imgs, err := cli.ImageList(ctx, image.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("reference", spec.Image)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("image list: %w", err)
+	}
+	if len(imgs) == 0 {
+		out, err := cli.ImagePull(ctx, spec.Image, image.PullOptions{})
+		if err != nil {
+			return "", fmt.Errorf("image pull: %w", err)
+		}
+		io.Copy(os.Stdout, out)
+		out.Close()
+	}
+
+	// Set up port mapping
+	portKey := nat.Port(spec.Port + "/tcp")
+	exposed := nat.PortSet{portKey: struct{}{}}
+	bindings := nat.PortMap{portKey: []nat.PortBinding{
+		{HostIP: "0.0.0.0", HostPort: spec.HostPort},
+	}}
+
+	resp, err := cli.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:        spec.Image,
+			Cmd:          spec.Cmd,
+			ExposedPorts: exposed,
+			Env:          spec.Env,
+			Labels:       spec.Labels,
+		},
+		&container.HostConfig{
+			PortBindings: bindings,
+		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				spec.Network: {},
+			},
+		},
+		nil,       // *specs.Platform
+		spec.Name, // container name
+	)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *DockerRuntime) Start(ctx context.Context, id string) error {
+	cli, err := d.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerStart(ctx, id, container.StartOptions{})
+}
+
+func (d *DockerRuntime) Stop(ctx context.Context, id string) error {
+	cli, err := d.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerStop(ctx, id, container.StopOptions{})
+}
+
+func (d *DockerRuntime) Remove(ctx context.Context, id string) error {
+	cli, err := d.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerRemove(ctx, id, container.RemoveOptions{})
+}
+
+func (d *DockerRuntime) Stats(ctx context.Context, id string, stream bool) (io.ReadCloser, error) {
+	cli, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.ContainerStats(ctx, id, stream)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (d *DockerRuntime) List(ctx context.Context, labels map[string]string, all bool) ([]structers.ContainerInfo, error) {
+	cli, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: all, Filters: labelFilters(labels)})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]structers.ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		info := structers.ContainerInfo{
+			ID:     c.ID,
+			State:  c.State,
+			Labels: c.Labels,
+			Ports:  make(map[string]string),
+		}
+		if len(c.Names) > 0 {
+			// Docker returns names prefixed with '/', so strip it off
+			info.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				info.Ports[strconv.Itoa(int(p.PrivatePort))] = strconv.Itoa(int(p.PublicPort))
+			}
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func (d *DockerRuntime) Inspect(ctx context.Context, id string) (structers.ContainerInfo, error) {
+	cli, err := d.client()
+	if err != nil {
+		return structers.ContainerInfo{}, err
+	}
+	insp, err := cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return structers.ContainerInfo{}, err
+	}
+
+	info := structers.ContainerInfo{Ports: make(map[string]string)}
+	if insp.ContainerJSONBase != nil {
+		info.ID = insp.ID
+		info.Name = strings.TrimPrefix(insp.Name, "/")
+		if insp.State != nil {
+			info.State = insp.State.Status
+		}
+	}
+	if insp.Config != nil {
+		info.Labels = insp.Config.Labels
+	}
+	if insp.NetworkSettings != nil {
+		for port, bindings := range insp.NetworkSettings.Ports {
+			if len(bindings) > 0 && bindings[0].HostPort != "" {
+				info.Ports[port.Port()] = bindings[0].HostPort
+			}
+		}
+	}
+	return info, nil
+}
+
+func (d *DockerRuntime) Events(ctx context.Context, labels map[string]string) (<-chan structers.ContainerEvent, <-chan error) {
+	out := make(chan structers.ContainerEvent)
+	errc := make(chan error, 1)
+
+	cli, err := d.client()
+	if err != nil {
+		errc <- err
+		return out, errc
+	}
+
+	args := labelFilters(labels)
+	args.Add("type", string(dockerEvents.ContainerEventType))
+	for _, action := range []dockerEvents.Action{
+		dockerEvents.ActionDie,
+		dockerEvents.ActionOOM,
+		dockerEvents.ActionKill,
+		dockerEvents.ActionHealthStatus,
+		dockerEvents.ActionDestroy,
+	} {
+		args.Add("event", string(action))
+	}
+	msgs, errs := cli.Events(ctx, dockerEvents.ListOptions{Filters: args})
+
+	go func() {
+		for {
+			select {
+			case msg := <-msgs:
+				ev := structers.ContainerEvent{
+					ID:     msg.Actor.ID,
+					Action: string(msg.Action),
+					Time:   time.Unix(0, msg.TimeNano),
+				}
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			case err := <-errs:
+				errc <- err
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+func (d *DockerRuntime) EnsureNetwork(ctx context.Context, spec structers.NetworkSpec) error {
+	cli, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	// Check if network already exists
+	networks, err := cli.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", spec.Name)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	// Skip if network already exists
+	if len(networks) > 0 {
+		log.Printf("Network %s already exists, skipping creation", spec.Name)
+		return nil
+	}
+
+	// Create network options
+	createOptions := network.CreateOptions{
+		Driver:  "bridge", // default driver
+		Labels:  spec.Labels,
+		Options: spec.Options,
+	}
+
+	// Apply custom driver if specified
+	if spec.Driver != "" {
+		createOptions.Driver = spec.Driver
+	}
+
+	// Create the network
+	resp, err := cli.NetworkCreate(ctx, spec.Name, createOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create network %s: %w", spec.Name, err)
+	}
+
+	log.Printf("Created network: %s (ID: %s)", spec.Name, resp.ID)
+	return nil
+}
+
+// labelFilters returns the Docker filters matching the containers carrying all the labels.
+func labelFilters(labels map[string]string) filters.Args {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+	return args
+}
@@ -0,0 +1,438 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+const (
+	// fakeMemoryLimit is the memory limit the fake containers report
+	fakeMemoryLimit = 1 << 30
+
+	// fakeSystemTick is the host cpu time between two fake stats samples, in nanoseconds
+	fakeSystemTick = uint64(time.Second)
+)
+
+// FakeRuntime is an in-memory ReplicaRuntime: a started container is an httptest server
+// running Handler, so the scaling and health logic can run without a Docker daemon.
+// Crash and SetUsage drive the containers the way a misbehaving replica would.
+type FakeRuntime struct {
+	// Handler serves the requests of every container, a nil one answers 200 to everything
+	Handler http.Handler
+
+	// StatsInterval is the time between two samples of a stats stream, a second by default
+	StatsInterval time.Duration
+
+	// StartHook, when set, gets the name of every container about to start, an error fails
+	// the start (the container stays created). It runs under the runtime's lock.
+	StartHook func(name string) error
+
+	// NoPorts keeps the started containers from publishing their port.
+	NoPorts bool
+
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*fakeContainer
+	networks   map[string]bool
+	subs       []fakeSubscription
+}
+
+// fakeContainer is one container of a FakeRuntime.
+type fakeContainer struct {
+	info   structers.ContainerInfo
+	port   string
+	server *httptest.Server
+
+	// cpuPct and memBytes are the usage its stats report, cpuTotal and systemTotal
+	// the counters they're derived from
+	cpuPct      float64
+	memBytes    uint64
+	cpuTotal    uint64
+	systemTotal uint64
+}
+
+// fakeSubscription is an Events caller.
+type fakeSubscription struct {
+	ctx    context.Context
+	labels map[string]string
+	ch     chan structers.ContainerEvent
+}
+
+// NewFakeRuntime returns an empty in-memory runtime whose containers serve handler.
+func NewFakeRuntime(handler http.Handler) *FakeRuntime {
+	return &FakeRuntime{
+		Handler:       handler,
+		StatsInterval: time.Second,
+		containers:    make(map[string]*fakeContainer),
+		networks:      make(map[string]bool),
+	}
+}
+
+// lookup finds a container by ID or name. f.mu must be held.
+func (f *FakeRuntime) lookup(id string) (*fakeContainer, error) {
+	if c, ok := f.containers[id]; ok {
+		return c, nil
+	}
+	for _, c := range f.containers {
+		if c.info.Name == id {
+			return c, nil
+		}
+	}
+	// same wording as Docker, the callers look for it
+	return nil, fmt.Errorf("No such container: %s", id)
+}
+
+// publish sends an event to the subscribers interested in the container, dropping it for
+// the ones not keeping up. f.mu must be held.
+func (f *FakeRuntime) publish(c *fakeContainer, action string) {
+	ev := structers.ContainerEvent{ID: c.info.ID, Action: action, Time: time.Now()}
+	live := f.subs[:0]
+	for _, s := range f.subs {
+		if s.ctx.Err() != nil {
+			continue
+		}
+		live = append(live, s)
+		if !hasLabels(c.info.Labels, s.labels) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+	f.subs = live
+}
+
+func (f *FakeRuntime) Create(ctx context.Context, spec structers.ReplicaSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.containers {
+		if spec.Name != "" && c.info.Name == spec.Name {
+			return "", fmt.Errorf("Conflict. The container name %q is already in use", spec.Name)
+		}
+	}
+
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	name := spec.Name
+	if name == "" {
+		name = id
+	}
+	f.containers[id] = &fakeContainer{
+		info: structers.ContainerInfo{
+			ID:     id,
+			Name:   name,
+			State:  ContainerCreated,
+			Labels: maps.Clone(spec.Labels),
+			Ports:  make(map[string]string),
+		},
+		port: spec.Port,
+	}
+	return id, nil
+}
+
+func (f *FakeRuntime) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if c.server != nil {
+		return nil
+	}
+	if f.StartHook != nil {
+		if err := f.StartHook(c.info.Name); err != nil {
+			return err
+		}
+	}
+
+	handler := f.Handler
+	if handler == nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	}
+	c.server = httptest.NewServer(handler)
+	u, err := url.Parse(c.server.URL)
+	if err != nil {
+		c.server.Close()
+		c.server = nil
+		return fmt.Errorf("fake server url: %w", err)
+	}
+	if !f.NoPorts {
+		c.info.Ports = map[string]string{c.port: u.Port()}
+	}
+	c.info.State = ContainerRunning
+	return nil
+}
+
+func (f *FakeRuntime) Stop(ctx context.Context, id string) error {
+	return f.stopContainer(id, ActionDie)
+}
+
+// stopContainer marks a running container exited and shuts its server down. The server is
+// closed once f.mu is released: Close waits for the in-flight requests, and their handler
+// may well call the runtime.
+func (f *FakeRuntime) stopContainer(id, action string) error {
+	f.mu.Lock()
+	c, err := f.lookup(id)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	server := c.server
+	if server != nil {
+		c.server = nil
+		c.info.State = ContainerExited
+		c.info.Ports = make(map[string]string)
+		f.publish(c, action)
+	}
+	f.mu.Unlock()
+
+	if server != nil {
+		// CloseClientConnections first, Close waits for the in-flight requests otherwise
+		server.CloseClientConnections()
+		server.Close()
+	}
+	return nil
+}
+
+func (f *FakeRuntime) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if c.server != nil {
+		return fmt.Errorf("cannot remove container %s: container is running", c.info.Name)
+	}
+	delete(f.containers, c.info.ID)
+	f.publish(c, ActionDestroy)
+	return nil
+}
+
+// Crash stops a container as if its process died (or was OOM killed with action "oom").
+func (f *FakeRuntime) Crash(id, action string) error {
+	return f.stopContainer(id, action)
+}
+
+// SetUsage sets the cpu (100% per cpu, the fake containers have one) and memory usage
+// the stats of a container report.
+func (f *FakeRuntime) SetUsage(id string, cpuPercent float64, memoryBytes uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	c.cpuPct, c.memBytes = cpuPercent, memoryBytes
+	return nil
+}
+
+// sample advances the counters of a container by one StatsInterval and returns its stats.
+// f.mu must be held.
+func (f *FakeRuntime) sample(c *fakeContainer) structers.StatsJSON {
+	var s structers.StatsJSON
+	s.ID, s.Name = c.info.ID, "/"+c.info.Name
+	s.Read = time.Now()
+	s.PreRead = s.Read.Add(-f.StatsInterval)
+
+	s.PreCPUStats.CPUUsage.TotalUsage = c.cpuTotal
+	s.PreCPUStats.SystemUsage = c.systemTotal
+	s.PreCPUStats.OnlineCPUs = 1
+	c.cpuTotal += uint64(c.cpuPct / 100 * float64(fakeSystemTick))
+	c.systemTotal += fakeSystemTick
+	s.CPUStats.CPUUsage.TotalUsage = c.cpuTotal
+	s.CPUStats.SystemUsage = c.systemTotal
+	s.CPUStats.OnlineCPUs = 1
+
+	s.MemoryStats.Usage = c.memBytes
+	s.MemoryStats.Limit = fakeMemoryLimit
+	s.MemoryStats.Stats = map[string]uint64{"inactive_file": 0}
+	s.PidsStats.Current = 1
+	return s
+}
+
+func (f *FakeRuntime) Stats(ctx context.Context, id string, stream bool) (io.ReadCloser, error) {
+	f.mu.Lock()
+	c, err := f.lookup(id)
+	if err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	first := f.sample(c)
+	f.mu.Unlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		if err := enc.Encode(first); err != nil || !stream {
+			pw.CloseWithError(err)
+			return
+		}
+
+		ticker := time.NewTicker(f.StatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			case <-ticker.C:
+			}
+
+			f.mu.Lock()
+			c, err := f.lookup(id)
+			var s structers.StatsJSON
+			if err == nil {
+				s = f.sample(c)
+			}
+			f.mu.Unlock()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if err := enc.Encode(s); err != nil {
+				// the reader is gone
+				return
+			}
+		}
+	}()
+	return pr, nil
+}
+
+func (f *FakeRuntime) List(ctx context.Context, labels map[string]string, all bool) ([]structers.ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []structers.ContainerInfo
+	for _, c := range f.containers {
+		if !hasLabels(c.info.Labels, labels) || (!all && c.info.State != ContainerRunning) {
+			continue
+		}
+		out = append(out, cloneContainerInfo(c.info))
+	}
+	return out, nil
+}
+
+func (f *FakeRuntime) Inspect(ctx context.Context, id string) (structers.ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.lookup(id)
+	if err != nil {
+		return structers.ContainerInfo{}, err
+	}
+	return cloneContainerInfo(c.info), nil
+}
+
+func (f *FakeRuntime) Events(ctx context.Context, labels map[string]string) (<-chan structers.ContainerEvent, <-chan error) {
+	ch := make(chan structers.ContainerEvent, 64)
+	errc := make(chan error, 1)
+
+	f.mu.Lock()
+	f.subs = append(f.subs, fakeSubscription{ctx: ctx, labels: labels, ch: ch})
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		errc <- ctx.Err()
+	}()
+	return ch, errc
+}
+
+func (f *FakeRuntime) EnsureNetwork(ctx context.Context, spec structers.NetworkSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.networks[spec.Name] = true
+	return nil
+}
+
+// hasLabels reports whether labels holds every key/value of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneContainerInfo copies the maps of info so the caller can't touch the fake's state.
+func cloneContainerInfo(info structers.ContainerInfo) structers.ContainerInfo {
+	info.Labels = maps.Clone(info.Labels)
+	info.Ports = maps.Clone(info.Ports)
+	return info
+}
+
+// useFakeRuntime runs the test against a fresh FakeRuntime serving handler and an empty
+// pool, the events it publishes landing in a temp dir.
+func useFakeRuntime(t *testing.T, handler http.Handler) *FakeRuntime {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	rt := NewFakeRuntime(handler)
+	prev := replicaRuntime
+	SetRuntime(rt)
+	resetPool()
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		list, _ := rt.List(ctx, nil, true)
+		for _, c := range list {
+			rt.Stop(ctx, c.ID)
+		}
+		SetRuntime(prev)
+		resetPool()
+	})
+	return rt
+}
+
+// resetPool forgets every backend and the state the scaling kept about them.
+func resetPool() {
+	config.BackendsMu.Lock()
+	config.Backends = nil
+	config.Unhealthy = nil
+	config.BackendsMu.Unlock()
+
+	suffixMu.Lock()
+	lastSuffix = 0
+	suffixMu.Unlock()
+
+	ResetCrashLoop()
+}
+
+// waitFor polls cond until it holds, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// readyCount returns the backends in rotation.
+func readyCount() int {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+	return config.Backends.Len()
+}
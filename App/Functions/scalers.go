@@ -4,126 +4,322 @@ package functions
 
 import (
 	"container/heap"
+	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
 	"github.com/xaydras-2/loadBalancer/App/structers"
 )
 
 var (
 	// this used to prevent race condition so that there isn't a multiple creation/deletion at the same
 	scalingMutex sync.Mutex
+
+	// pendingCreates counts the containers being created, not registered yet,
+	// so the scaling decisions and the MaxReplicas check count them already
+	pendingCreates int32
+
+	// removingReplicas counts the replicas a scale down took out of rotation and is still
+	// draining or closing, their containers still count against MaxReplicas
+	removingReplicas int32
 )
 
+func init() {
+	metrics.GaugeFunc("lb_scaling_pending_creates", "Containers being created by a scale up.", func() float64 {
+		return float64(atomic.LoadInt32(&pendingCreates))
+	})
+	metrics.GaugeFunc("lb_scaling_removing_replicas", "Replicas taken out of rotation by a scale down, not closed yet.", func() float64 {
+		return float64(atomic.LoadInt32(&removingReplicas))
+	})
+	metrics.Describe("lb_scaling_failures_total", metrics.Counter, "Replicas a scale operation failed to add or remove, by action.")
+}
+
 // ScaleUp launches one more container and registers it.
 func ScaleUp() {
+	ScaleUpN(1)
+}
+
+// ScaleUpN launches n more containers, at most ScaleConcurrency created at a time, and
+// registers them as starting. The replicas that could be created are kept when others fail.
+func ScaleUpN(n int) structers.ScaleResult {
 
 	scalingMutex.Lock()
 	defer scalingMutex.Unlock()
 
+	start := time.Now()
+	before := replicaCount()
+	res := structers.ScaleResult{Requested: n}
+
+	// reserve the room first so the parallel creations can't overshoot MaxReplicas
+	config.BackendsMu.Lock()
+	room := config.MaxReplicas - containerCount() - int(atomic.LoadInt32(&pendingCreates))
+	n = max(min(n, room), 0)
+	atomic.AddInt32(&pendingCreates, int32(n))
+	config.BackendsMu.Unlock()
+
+	for range res.Requested - n {
+		res.Failed = append(res.Failed, fmt.Sprintf("cannot scale up beyond MaxReplicas (%d)", config.MaxReplicas))
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, config.ScaleConcurrency)
+	for range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b, err := startPendingReplica(false)
+			atomic.AddInt32(&pendingCreates, -1)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Failed = append(res.Failed, err.Error())
+				return
+			}
+			res.Succeeded = append(res.Succeeded, b.ContainerID)
+		}()
+	}
+	wg.Wait()
+
+	reportScaleResult(EventScaleUp, res, before, start)
+	return res
+}
+
+// startPendingReplica creates a container and registers it as starting, its startup probe
+// brings it in rotation once it answers. scalingMutex must be held.
+func startPendingReplica(replacement bool) (*structers.Backend, error) {
+	return startReplicaAttempt(replacement, 0)
+}
+
+// startReplicaAttempt is startPendingReplica for the given retry attempt of the startup probe.
+func startReplicaAttempt(replacement bool, attempt int) (*structers.Backend, error) {
+	start := time.Now()
 	backend, err := CreateReplicas(
 		config.ImageName,
 		config.ContainerPort,
 		config.NetworkName,
 	)
 	if err != nil {
-		log.Printf("scale up failed: %v", err)
-		return
+		// a failed replacement is reported by the remediation
+		if !replacement {
+			n := replicaCount()
+			publishEvent(structers.Event{
+				Type:     EventReplicaFailed,
+				Pool:     config.ParentName,
+				Reason:   "container creation failed",
+				Before:   n,
+				After:    n,
+				Duration: time.Since(start),
+				Error:    err.Error(),
+			})
+		}
+		return nil, err
 	}
 
-	// mark it as “not ready yet”
-	backend.Alive = false
-	backend.Ill = true
-
-	config.BackendsMu.Lock()
-	total := config.Backends.Len() + len(config.Unhealthy)
-	if total >= config.MaxReplicas {
-		config.BackendsMu.Unlock()
-		log.Printf("cannot scale up beyond MaxReplicas (%d)", config.MaxReplicas)
+	if err := registerStartingReplica(backend, replacement, attempt); err != nil {
 		CloseReplicas(backend.ContainerID)
-		return
+		return nil, err
 	}
 
-	config.Unhealthy = append(config.Unhealthy, backend)
-	nowTotal := total + 1
-	config.BackendsMu.Unlock()
-
-	// 2) fire the immediate‐check event (non‑blocking)
-	select {
-	case config.NewBackendTrigger <- backend:
-	default:
-		// if buffer is full, we can safely drop it—
-		// the next ticker tick will still check it.
-	}
-
-	log.Printf("scale up in progress: now %d total replicas (pending: %d)", nowTotal, len(config.Unhealthy))
+	// a scale up still counts it as pending, don't count it twice
+	n := replicaCount() - int(atomic.LoadInt32(&pendingCreates))
+	publishEvent(structers.Event{
+		Type:        EventReplicaCreated,
+		Pool:        backend.Pool,
+		Reason:      fmt.Sprintf("container created, waiting for its startup probe (attempt %d)", attempt+1),
+		ContainerID: backend.ContainerID,
+		Before:      n - 1,
+		After:       n,
+		Duration:    time.Since(start),
+	})
+	return backend, nil
 }
 
-// ScaleDown tears down the newest container—never going below MinReplicas.
+// ScaleDown drains and tears down one container—never going below MinReplicas.
 func ScaleDown() {
+	ScaleDownN(1)
+}
+
+// ScaleDownN drains and tears down up to n containers picked by config.ScaleDownVictimPolicy,
+// never going below the replica floor in effect. The replicas younger than MinReplicaLifetime
+// are left alone.
+// It returns once the victims are out of rotation, Succeeded listing them: the drains and
+// closes go on in the background, at most ScaleConcurrency at a time, and the scale_down
+// event reports their outcome. A replica that fails to close goes back in rotation.
+func ScaleDownN(n int) structers.ScaleResult {
+	res := structers.ScaleResult{Requested: n}
 
 	scalingMutex.Lock()
-	defer scalingMutex.Unlock()
+	start := time.Now()
+	before := replicaCount()
+	floor, _ := replicaBounds(time.Now())
+	candidates := victimCandidates()
 
 	config.BackendsMu.Lock()
-	defer config.BackendsMu.Unlock()
+	// the replicas in rotation victimCandidates left out for their age
+	young := 0
+	for _, b := range config.Backends {
+		if b.Alive && atomic.LoadInt32(&b.ShuttingDown) == 0 && time.Since(b.StartTime) < config.MinReplicaLifetime {
+			young++
+		}
+	}
+	floorHit := false
+	var victims []*structers.Backend
+	for _, b := range candidates {
+		if len(victims) == n {
+			break
+		}
+		if config.Backends.Len() <= floor {
+			floorHit = true
+			break
+		}
+		// its state changed while the candidates were ranked
+		if !inHeap(b) || !b.Alive || atomic.LoadInt32(&b.ShuttingDown) == 1 {
+			continue
+		}
 
-	if config.Backends.Len() <= config.MinReplicas {
-		log.Printf("cannot scale down below MinReplicas (%d)", config.MinReplicas)
-		return
+		// out of the heap so no new request is routed to it, the in-flight ones finish
+		heap.Remove(&config.Backends, b.HeapIdx)
+		removeFromUnHealthy(b)
+		atomic.StoreInt32(&b.ShuttingDown, 1)
+		b.Draining = true
+		b.Alive = false
+		noteTransition(b, SourceScaling, probeResult{})
+		atomic.AddInt32(&removingReplicas, 1)
+		victims = append(victims, b)
 	}
+	config.BackendsMu.Unlock()
+	scalingMutex.Unlock()
 
-	if config.Backends.Len() == 0 {
-		log.Printf("no backends available to scale down")
-		return
+	short := n - len(victims)
+	if floorHit {
+		for range short {
+			res.Failed = append(res.Failed, fmt.Sprintf("cannot scale down below %d replicas", floor))
+		}
+	} else {
+		// out of candidates: the young replicas were skipped for their age, the others
+		// changed state while they were ranked
+		for range min(short, young) {
+			res.Failed = append(res.Failed, fmt.Sprintf("no replica older than %v", config.MinReplicaLifetime))
+		}
+		for range short - min(short, young) {
+			res.Failed = append(res.Failed, "no replica left to remove")
+		}
 	}
 
-	// Pop the least-loaded backend from the heap
-	b := heap.Pop(&config.Backends).(*structers.Backend)
+	// the drains can take up to DrainTimeout, don't hold the caller (the AutoScaler) meanwhile
+	outcome := structers.ScaleResult{Requested: n, Failed: slices.Clone(res.Failed)}
+	go func() {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, config.ScaleConcurrency)
+		for _, b := range victims {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer atomic.AddInt32(&removingReplicas, -1)
+				drainBackend(b)
+				err := closeVictim(b)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					outcome.Failed = append(outcome.Failed, err.Error())
+					return
+				}
+				outcome.Succeeded = append(outcome.Succeeded, b.ContainerID)
+			}()
+		}
+		wg.Wait()
 
-	removeFromUnHealthy(b)
+		reportScaleResult(EventScaleDown, outcome, before, start)
+	}()
 
-	// Mark as shutting down to prevent new requests
-	atomic.StoreInt32(&b.ShuttingDown, 1)
+	for _, b := range victims {
+		res.Succeeded = append(res.Succeeded, b.ContainerID)
+	}
+	return res
+}
 
-	b.Alive = false
+// closeVictim closes the container of a backend drained by ScaleDownN,
+// putting it back in rotation if that fails.
+func closeVictim(b *structers.Backend) error {
+	_, err := CloseReplicas(b.ContainerID)
+	if err == nil {
+		return nil
+	}
+	// If the error is "No such container", just log and do NOT re-add to heap
+	if strings.Contains(err.Error(), "No such container") {
+		log.Printf("container %s already removed, skipping re-add", b.ContainerID)
+		return nil
+	}
 
-	// Wait for in-flight requests to finish
-	// get the current load
-	currentLoad := atomic.LoadInt64(&b.CurrentLoad)
+	// Put backend back if shutdown failed
+	config.BackendsMu.Lock()
+	atomic.StoreInt32(&b.ShuttingDown, 0)
+	b.Draining = false
+	b.Alive = true
+	noteTransition(b, SourceScaling, probeResult{})
+	heap.Push(&config.Backends, b)
+	config.BackendsMu.Unlock()
+	return fmt.Errorf("close %s: %w", b.ContainerID, err)
+}
 
-	if currentLoad > 0 {
-		log.Printf("backend %s has %d active requests, cannot scale down now",
-			b.ContainerID, currentLoad)
-		atomic.StoreInt32(&b.ShuttingDown, 0)
-		heap.Push(&config.Backends, b)
-		return
+// reportScaleResult logs the outcome of a scale operation (EventScaleUp or EventScaleDown),
+// counts its failures and publishes its event.
+func reportScaleResult(eventType string, res structers.ScaleResult, before int, start time.Time) {
+	action := strings.ReplaceAll(eventType, "_", " ")
+	ev := structers.Event{
+		Type:     eventType,
+		Pool:     config.ParentName,
+		Reason:   fmt.Sprintf("%d/%d replicas done", len(res.Succeeded), res.Requested),
+		Before:   before,
+		After:    replicaCount(),
+		Duration: time.Since(start),
 	}
+	if len(res.Failed) > 0 {
+		ev.Error = strings.Join(res.Failed, "; ")
+		metrics.Add("lb_scaling_failures_total", float64(len(res.Failed)), "action", eventType)
+		log.Printf("%s: %d/%d done, failed: %s", action, len(res.Succeeded), res.Requested, ev.Error)
+	}
+	publishEvent(ev)
 
-	// Backend has no active requests, safe to shut down
-	if _, err := CloseReplicas(b.ContainerID); err != nil {
-		// If the error is "No such container", just log and do NOT re-add to heap
-		if strings.Contains(err.Error(), "No such container") {
-			log.Printf("container %s already removed, skipping re-add", b.ContainerID)
-			return
+	config.BackendsMu.Lock()
+	ready := config.Backends.Len()
+	config.BackendsMu.Unlock()
+	log.Printf("%s: %d replicas in rotation (%d done, %d pending creation)",
+		action, ready, len(res.Succeeded), atomic.LoadInt32(&pendingCreates))
+}
+
+// containerCount returns the containers of the pool, in rotation, ill or being removed,
+// an ill backend being in both lists. config.BackendsMu must be held.
+func containerCount() int {
+	n := config.Backends.Len() + int(atomic.LoadInt32(&removingReplicas))
+	for _, b := range config.Unhealthy {
+		if !inHeap(b) {
+			n++
 		}
-		log.Printf("scale down failed: %v", err)
-		removeFromUnHealthy(b)
-		// Put backend back if shutdown failed
-		heap.Push(&config.Backends, b)
-		return
 	}
-
-	log.Printf("scaled down: removed %q, now %d replicas", b.ContainerID, config.Backends.Len())
+	return n
 }
 
 func removeFromUnHealthy(b *structers.Backend) {
 	for i, ub := range config.Unhealthy {
 		if ub == b {
-			heap.Remove(&config.Unhealthy, i)
+			// not heap.Remove: the list isn't a heap and swapping would rewrite the
+			// HeapIdx the backend may still hold in config.Backends
+			config.Unhealthy = slices.Delete(config.Unhealthy, i, i+1)
 			break
 		}
 	}
@@ -0,0 +1,100 @@
+package functions
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+)
+
+func TestScaleUpNWaitsForTheStartupProbe(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+
+	res := ScaleUpN(2)
+	if len(res.Succeeded) != 2 || len(res.Failed) != 0 {
+		t.Fatalf("ScaleUpN(2) = %+v, want 2 replicas created", res)
+	}
+	// created, not in rotation before their startup probe passes
+	if got := replicaCount(); got != 2 {
+		t.Errorf("replicaCount() = %d right after the scale up, want 2", got)
+	}
+
+	waitFor(t, 5*time.Second, "the replicas to pass their startup probe", func() bool { return readyCount() == 2 })
+
+	list, err := rt.List(context.Background(), map[string]string{"com.docker.compose.service": config.ParentName}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range list {
+		names = append(names, c.Name)
+	}
+	slices.Sort(names)
+	if want := []string{config.ParentName + "-1", config.ParentName + "-2"}; !slices.Equal(names, want) {
+		t.Errorf("containers = %v, want %v", names, want)
+	}
+}
+
+func TestScaleUpNStopsAtMaxReplicas(t *testing.T) {
+	useFakeRuntime(t, nil)
+
+	res := ScaleUpN(config.MaxReplicas + 1)
+	if len(res.Succeeded) != config.MaxReplicas || len(res.Failed) != 1 {
+		t.Fatalf("ScaleUpN(%d) = %+v, want %d created and 1 failed", config.MaxReplicas+1, res, config.MaxReplicas)
+	}
+	if !strings.Contains(res.Failed[0], "MaxReplicas") {
+		t.Errorf("failure = %q, want the MaxReplicas one", res.Failed[0])
+	}
+	waitFor(t, 5*time.Second, "the replicas to get ready", func() bool { return readyCount() == config.MaxReplicas })
+}
+
+func TestScaleDownNDrainsInTheBackground(t *testing.T) {
+	rt := useFakeRuntime(t, nil)
+
+	ScaleUpN(3)
+	waitFor(t, 5*time.Second, "the replicas to get ready", func() bool { return readyCount() == 3 })
+
+	// too young to be removed yet
+	if res := ScaleDownN(1); len(res.Succeeded) != 0 || len(res.Failed) != 1 || !strings.Contains(res.Failed[0], "older than") {
+		t.Fatalf("ScaleDownN(1) on young replicas = %+v, want 1 lifetime failure", res)
+	}
+
+	config.BackendsMu.Lock()
+	for _, b := range config.Backends {
+		b.StartTime = b.StartTime.Add(-config.MinReplicaLifetime)
+	}
+	config.BackendsMu.Unlock()
+
+	res := ScaleDownN(3)
+	if len(res.Succeeded) != 2 {
+		t.Fatalf("ScaleDownN(3) = %+v, want 2 removed", res)
+	}
+	if len(res.Failed) != 1 || !strings.Contains(res.Failed[0], "below") {
+		t.Errorf("failures = %q, want the replica floor one", res.Failed)
+	}
+	// out of rotation right away, counted until closed
+	if got := readyCount(); got != 1 {
+		t.Errorf("%d replicas in rotation after the scale down, want 1", got)
+	}
+
+	waitFor(t, 5*time.Second, "the victims to be closed", func() bool { return atomic.LoadInt32(&removingReplicas) == 0 })
+	if got := replicaCount(); got != 1 {
+		t.Errorf("replicaCount() = %d, want 1", got)
+	}
+	list, err := rt.List(context.Background(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Errorf("%d containers left, want 1", len(list))
+	}
+	for _, id := range res.Succeeded {
+		if _, err := rt.Inspect(context.Background(), id); err == nil {
+			t.Errorf("victim %s still exists", id)
+		}
+	}
+}
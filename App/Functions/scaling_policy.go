@@ -0,0 +1,251 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// Scaling actions
+const (
+	ScaleActionUp   = "scale_up"
+	ScaleActionDown = "scale_down"
+	ScaleActionNone = "none"
+)
+
+// ScalingSignal is one input of the scaling controller (request volume, resource usage...).
+// Recommend is called once per evaluation with the current replica count and returns the
+// replica count the signal would like, an error drops it from the evaluation.
+type ScalingSignal interface {
+	Name() string
+	Recommend(current int) (structers.ScalingRecommendation, error)
+}
+
+var (
+	// scalingSignals are evaluated in order, on a tie the first one wins
+	scalingSignals = []ScalingSignal{
+		&requestSignal{},
+		concurrencySignal{},
+		latencySignal{},
+		&resourceSignal{},
+		predictiveSignal{},
+	}
+
+	// scalingStateMu guards lastScaleUp, lastScaleDown and scalingDecisions
+	scalingStateMu sync.Mutex
+	lastScaleUp    time.Time
+	lastScaleDown  time.Time
+
+	// scalingDecisions keeps the last config.MaxScalingDecisions decisions, oldest first
+	scalingDecisions []structers.ScalingDecision
+)
+
+func init() {
+	metrics.Describe("lb_scaling_decisions_total", metrics.Counter, "Evaluations of the scaling controller, by action and winning signal.")
+	metrics.Describe("lb_scaling_desired_replicas", metrics.Gauge, "Replica count picked by the scaling policy at the last evaluation.")
+	metrics.Describe("lb_scaling_suppressed_total", metrics.Counter, "Scaling decisions held back by a stabilization window, a rate limit, the replica lifetime or a cooldown.")
+	metrics.Describe("lb_scaling_signal_desired_replicas", metrics.Gauge, "Replica count recommended by each scaling signal at the last evaluation.")
+}
+
+// evaluateScaling asks every signal for a recommendation and combines them under
+// config.ScalingPolicy into a decision, with the pool bounds, the stabilization windows,
+// the rate limits and the cooldowns applied.
+func evaluateScaling() structers.ScalingDecision {
+	current := replicaCount()
+	d := structers.ScalingDecision{
+		Time:    time.Now(),
+		Pool:    config.ParentName,
+		Policy:  config.ScalingPolicy,
+		Current: current,
+		Desired: current,
+		Action:  ScaleActionNone,
+	}
+
+	for _, s := range scalingSignals {
+		rec, err := s.Recommend(current)
+		rec.Signal = s.Name()
+		if err != nil {
+			rec.Error = err.Error()
+		} else {
+			metrics.Set("lb_scaling_signal_desired_replicas", float64(rec.Desired), "signal", rec.Signal)
+		}
+		d.Recommendations = append(d.Recommendations, rec)
+	}
+
+	winner := pickRecommendation(config.ScalingPolicy, d.Recommendations)
+	if winner == nil {
+		d.Recommended = current
+		d.Reason = "no usable signal"
+		return d
+	}
+	d.Winner = winner.Signal
+	d.Desired = winner.Desired
+	d.Reason = winner.Reason
+
+	// pool bounds, as moved by the open schedules. When the bound is what moves the pool
+	// (a window opening or closing) it goes ScheduleConvergeStep replicas at a time.
+	lo, hi := replicaBounds(d.Time)
+	switch want := d.Desired; {
+	case want > hi:
+		d.Desired = hi
+		if hi < current {
+			d.Desired = max(hi, min(current, want)-config.ScheduleConvergeStep)
+		}
+		d.Reason += fmt.Sprintf(", capped at %d replicas", hi)
+	case want < lo:
+		d.Desired = lo
+		if lo > current {
+			d.Desired = min(lo, max(current, want)+config.ScheduleConvergeStep)
+		}
+		d.Reason += fmt.Sprintf(", floored at %d replicas", lo)
+	}
+	// the last replica only goes away once the pool has been idle long enough
+	if d.Desired == 0 && current > 0 && time.Since(lastRequestTime()) < config.ScaleToZeroIdle {
+		d.Desired = 1
+		d.Reason += fmt.Sprintf(", kept 1 replica (idle for less than %v)", config.ScaleToZeroIdle)
+	}
+
+	d.Recommended = d.Desired
+	stabilizeDecision(&d, removableReplicas())
+
+	switch {
+	case d.Desired > current:
+		d.Action = ScaleActionUp
+	case d.Desired < current:
+		d.Action = ScaleActionDown
+	}
+
+	if wait := scalingCooldown(d.Action, d.Time); wait > 0 {
+		d.Suppressed = append(d.Suppressed, fmt.Sprintf("%s in cooldown for %v", d.Action, wait.Round(time.Second)))
+		d.Desired = current
+		d.Action = ScaleActionNone
+	}
+	return d
+}
+
+// pickRecommendation returns the recommendation chosen by the policy, nil when none is usable.
+func pickRecommendation(policy string, recs []structers.ScalingRecommendation) *structers.ScalingRecommendation {
+	var best *structers.ScalingRecommendation
+	for i := range recs {
+		r := &recs[i]
+		if r.Error != "" {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = r
+		case policy == "min" && r.Desired < best.Desired:
+			best = r
+		case policy != "min" && r.Desired > best.Desired:
+			best = r
+		}
+	}
+	return best
+}
+
+// scalingCooldown returns how long the given action still has to wait, zero when it can go.
+func scalingCooldown(action string, now time.Time) time.Duration {
+	scalingStateMu.Lock()
+	defer scalingStateMu.Unlock()
+
+	switch action {
+	case ScaleActionUp:
+		return lastScaleUp.Add(config.ScaleUpCooldown).Sub(now)
+	case ScaleActionDown:
+		last := lastScaleDown
+		if lastScaleUp.After(last) {
+			last = lastScaleUp
+		}
+		return last.Add(config.ScaleDownCooldown).Sub(now)
+	}
+	return 0
+}
+
+// applyScalingDecision scales the pool toward the desired count and records the decision.
+// A pool in dry-run mode only logs what it would have done.
+func applyScalingDecision(d structers.ScalingDecision) {
+	d.DryRun = DryRunEnabled(d.Pool)
+
+	switch {
+	case d.DryRun:
+		if d.Action != ScaleActionNone {
+			logDryRun(d)
+		}
+	case d.Action == ScaleActionUp:
+		res := ScaleUpN(d.Desired - d.Current)
+		d.Result = &res
+	case d.Action == ScaleActionDown:
+		// d.Current still counts the replicas an earlier scale down is removing
+		res := structers.ScaleResult{}
+		if n := d.Current - d.Desired - int(atomic.LoadInt32(&removingReplicas)); n > 0 {
+			res = ScaleDownN(n)
+		}
+		d.Result = &res
+	}
+
+	scalingStateMu.Lock()
+	// nothing moved in dry-run, the cooldowns and rate limits stay as they were
+	switch {
+	case d.DryRun:
+	case d.Action == ScaleActionUp:
+		lastScaleUp = d.Time
+		recordScaleEvent(d.Time, len(d.Result.Succeeded))
+	case d.Action == ScaleActionDown:
+		lastScaleDown = d.Time
+		recordScaleEvent(d.Time, -len(d.Result.Succeeded))
+	}
+	recordScalingDecision(d)
+	scalingStateMu.Unlock()
+
+	metrics.Inc("lb_scaling_decisions_total", "action", d.Action, "signal", d.Winner)
+	metrics.Set("lb_scaling_desired_replicas", float64(d.Desired))
+	if d.Action != ScaleActionNone && !d.DryRun {
+		log.Printf("scaling: %s %d -> %d, %s won (%s policy): %s",
+			d.Action, d.Current, d.Desired, d.Winner, d.Policy, d.Reason)
+	}
+	if len(d.Suppressed) > 0 {
+		metrics.Inc("lb_scaling_suppressed_total")
+		log.Printf("scaling: suppressed %d -> %d (kept %d), %s won: %s; %s",
+			d.Current, d.Recommended, d.Desired, d.Winner, d.Reason, strings.Join(d.Suppressed, "; "))
+	}
+}
+
+// recordScalingDecision keeps d for the admin API. scalingStateMu must be held.
+func recordScalingDecision(d structers.ScalingDecision) {
+	scalingDecisions = append(scalingDecisions, d)
+	if len(scalingDecisions) > config.MaxScalingDecisions {
+		scalingDecisions = scalingDecisions[len(scalingDecisions)-config.MaxScalingDecisions:]
+	}
+}
+
+// recentScalingDecisions returns a copy of the kept decisions, oldest first.
+func recentScalingDecisions() []structers.ScalingDecision {
+	scalingStateMu.Lock()
+	defer scalingStateMu.Unlock()
+	return append([]structers.ScalingDecision(nil), scalingDecisions...)
+}
+
+// replicaCount returns the replicas in rotation plus the ones being created or still
+// starting, so a replica on its way isn't asked for twice, and the ones a scale down is
+// still removing, so one on its way out isn't removed twice.
+func replicaCount() int {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	n := config.Backends.Len() + int(atomic.LoadInt32(&pendingCreates)) + int(atomic.LoadInt32(&removingReplicas))
+	for _, b := range config.Unhealthy {
+		if b.Starting && atomic.LoadInt32(&b.ShuttingDown) == 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	// the zone database is embedded, the containers running us may not ship one
+	_ "time/tzdata"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// scheduleWindow is a loaded schedule with its cron expressions parsed.
+type scheduleWindow struct {
+	rule       structers.ScalingSchedule
+	start, end cronExpr
+	loc        *time.Location
+}
+
+// replicaBoundsView is what the admin API returns about the schedules.
+type replicaBoundsView struct {
+	MinReplicas int                         `json:"min_replicas"`
+	MaxReplicas int                         `json:"max_replicas"`
+	Active      []string                    `json:"active"`
+	Schedules   []structers.ScalingSchedule `json:"schedules"`
+}
+
+var (
+	// schedulesMu guards scheduleWindows and boundsCache
+	schedulesMu     sync.Mutex
+	scheduleWindows []scheduleWindow
+
+	// boundsCache holds the bounds of the current minute, the windows only move on minutes
+	boundsCache replicaBoundsView
+	boundsAt    time.Time
+)
+
+func init() {
+	metrics.Describe("lb_schedule_active", metrics.Gauge, "1 while the scheduled scaling window is open.")
+	metrics.Describe("lb_replicas_min", metrics.Gauge, "Replica floor in effect, schedules included.")
+	metrics.Describe("lb_replicas_max", metrics.Gauge, "Replica cap in effect, schedules included.")
+}
+
+// WatchSchedules loads the scheduled scaling windows from config.SchedulesPath and reloads
+// them every time the file changes. Meant to run in its own goroutine.
+func WatchSchedules() {
+	var lastMod time.Time
+	reload := func() {
+		info, err := os.Stat(config.SchedulesPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			if !lastMod.IsZero() {
+				log.Printf("schedules file %s removed, schedules disabled", config.SchedulesPath)
+				setScheduleWindows(nil)
+				lastMod = time.Time{}
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("schedules stat: %v", err)
+			return
+		}
+		if info.ModTime().Equal(lastMod) {
+			return
+		}
+
+		windows, err := loadSchedules(config.SchedulesPath)
+		if err != nil {
+			log.Printf("schedules reload failed, keeping previous ones: %v", err)
+			return
+		}
+		lastMod = info.ModTime()
+		setScheduleWindows(windows)
+		log.Printf("scaling schedules loaded: %d", len(windows))
+	}
+
+	reload()
+	ticker := time.NewTicker(config.ScheduleReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reload()
+	}
+}
+
+// loadSchedules reads and validates the schedules file.
+func loadSchedules(path string) ([]scheduleWindow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read schedules: %w", err)
+	}
+
+	var file struct {
+		Schedules []structers.ScalingSchedule `json:"schedules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode schedules: %w", err)
+	}
+
+	windows := make([]scheduleWindow, 0, len(file.Schedules))
+	for i, rule := range file.Schedules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("schedule %d: missing name", i)
+		}
+		w := scheduleWindow{rule: rule, loc: time.UTC}
+		if w.start, err = parseCron(rule.Start); err != nil {
+			return nil, fmt.Errorf("schedule %q start: %w", rule.Name, err)
+		}
+		if w.end, err = parseCron(rule.End); err != nil {
+			return nil, fmt.Errorf("schedule %q end: %w", rule.Name, err)
+		}
+		if rule.Timezone != "" {
+			if w.loc, err = time.LoadLocation(rule.Timezone); err != nil {
+				return nil, fmt.Errorf("schedule %q: %w", rule.Name, err)
+			}
+		}
+		if rule.MinReplicas == nil && rule.MaxReplicas == nil {
+			return nil, fmt.Errorf("schedule %q: sets neither min_replicas nor max_replicas", rule.Name)
+		}
+		if rule.MinReplicas != nil && (*rule.MinReplicas < 0 || *rule.MinReplicas > config.MaxReplicas) {
+			return nil, fmt.Errorf("schedule %q: min_replicas must be within 0-%d", rule.Name, config.MaxReplicas)
+		}
+		if rule.MaxReplicas != nil && (*rule.MaxReplicas < 0 || *rule.MaxReplicas > config.MaxReplicas) {
+			return nil, fmt.Errorf("schedule %q: max_replicas must be within 0-%d", rule.Name, config.MaxReplicas)
+		}
+		if rule.MinReplicas != nil && rule.MaxReplicas != nil && *rule.MinReplicas > *rule.MaxReplicas {
+			return nil, fmt.Errorf("schedule %q: min_replicas above max_replicas", rule.Name)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// setScheduleWindows swaps the loaded windows, the bounds are recomputed on the next call.
+func setScheduleWindows(windows []scheduleWindow) {
+	schedulesMu.Lock()
+	old := scheduleWindows
+	scheduleWindows = windows
+	boundsAt = time.Time{}
+	schedulesMu.Unlock()
+
+	for _, w := range old {
+		metrics.Delete("lb_schedule_active", "schedule", w.rule.Name)
+	}
+}
+
+// open reports whether the window is open at t: its last start is after its last end.
+func (w scheduleWindow) open(t time.Time) bool {
+	t = t.In(w.loc)
+	start, ok := w.start.lastMatch(t, config.ScheduleLookback)
+	if !ok {
+		return false
+	}
+	end, ok := w.end.lastMatch(t, config.ScheduleLookback)
+	return !ok || start.After(end)
+}
+
+// replicaBounds returns the replica floor and cap in effect at t: config.MinReplicas and
+// config.MaxReplicas, overridden by the open schedules. The schedules never go above
+// config.MaxReplicas, which stays the hard cap of the pool.
+func replicaBounds(t time.Time) (int, int) {
+	v := replicaBoundsStatus(t)
+	return v.MinReplicas, v.MaxReplicas
+}
+
+// replicaBoundsStatus computes the bounds at t along with the open windows.
+func replicaBoundsStatus(t time.Time) replicaBoundsView {
+	minute := t.Truncate(time.Minute)
+
+	schedulesMu.Lock()
+	if boundsAt.Equal(minute) {
+		v := boundsCache
+		schedulesMu.Unlock()
+		return v
+	}
+
+	v := replicaBoundsView{MinReplicas: config.MinReplicas, MaxReplicas: config.MaxReplicas}
+	minPrio, maxPrio := 0, 0
+	minSet, maxSet := false, false
+	open := make(map[string]bool, len(scheduleWindows))
+	for _, w := range scheduleWindows {
+		v.Schedules = append(v.Schedules, w.rule)
+		if !w.open(t) {
+			continue
+		}
+		open[w.rule.Name] = true
+		v.Active = append(v.Active, w.rule.Name)
+		// the first declared one wins a tie, hence the strict comparison
+		if w.rule.MinReplicas != nil && (!minSet || w.rule.Priority > minPrio) {
+			v.MinReplicas, minPrio, minSet = *w.rule.MinReplicas, w.rule.Priority, true
+		}
+		if w.rule.MaxReplicas != nil && (!maxSet || w.rule.Priority > maxPrio) {
+			v.MaxReplicas, maxPrio, maxSet = *w.rule.MaxReplicas, w.rule.Priority, true
+		}
+	}
+	// a window raising the floor above another's cap: the floor wins, capacity is what it asks for
+	v.MaxReplicas = max(v.MaxReplicas, v.MinReplicas)
+
+	changed := !boundsAt.IsZero() && (boundsCache.MinReplicas != v.MinReplicas || boundsCache.MaxReplicas != v.MaxReplicas)
+	boundsCache, boundsAt = v, minute
+	schedulesMu.Unlock()
+
+	if changed {
+		log.Printf("scaling bounds now [%d, %d] (open schedules: %v)", v.MinReplicas, v.MaxReplicas, v.Active)
+	}
+	for _, s := range v.Schedules {
+		active := 0.0
+		if open[s.Name] {
+			active = 1
+		}
+		metrics.Set("lb_schedule_active", active, "schedule", s.Name)
+	}
+	metrics.Set("lb_replicas_min", float64(v.MinReplicas))
+	metrics.Set("lb_replicas_max", float64(v.MaxReplicas))
+	return v
+}
@@ -0,0 +1,136 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// desiredSample is one recommended replica count, kept for the stabilization windows.
+type desiredSample struct {
+	at      time.Time
+	desired int
+}
+
+// scaleEvent is one scale operation, delta replicas added (or removed when negative),
+// kept for the rate limits.
+type scaleEvent struct {
+	at    time.Time
+	delta int
+}
+
+var (
+	// desiredHistory and scaleEvents are guarded by scalingStateMu, oldest first
+	desiredHistory []desiredSample
+	scaleEvents    []scaleEvent
+)
+
+// stabilizeDecision holds the recommended count of d back, so the pool doesn't flap:
+// the stabilization windows first, then the rate limits, then the replica lifetime.
+// removable is the number of replicas old enough to be removed.
+func stabilizeDecision(d *structers.ScalingDecision, removable int) {
+	scalingStateMu.Lock()
+	defer scalingStateMu.Unlock()
+
+	now := d.Time
+	desiredHistory = append(desiredHistory, desiredSample{at: now, desired: d.Desired})
+	keep := max(config.ScaleDownStabilization, config.ScaleUpStabilization)
+	for len(desiredHistory) > 0 && now.Sub(desiredHistory[0].at) > keep {
+		desiredHistory = desiredHistory[1:]
+	}
+
+	switch {
+	case d.Desired < d.Current:
+		highest := d.Desired
+		for _, s := range desiredHistory {
+			if now.Sub(s.at) <= config.ScaleDownStabilization {
+				highest = max(highest, s.desired)
+			}
+		}
+		if highest > d.Desired {
+			d.Desired = min(highest, d.Current)
+			d.Suppressed = append(d.Suppressed, fmt.Sprintf(
+				"scale down stabilization: highest recommendation over the last %v is %d", config.ScaleDownStabilization, highest))
+		}
+
+	case d.Desired > d.Current && config.ScaleUpStabilization > 0:
+		lowest := d.Desired
+		for _, s := range desiredHistory {
+			if now.Sub(s.at) <= config.ScaleUpStabilization {
+				lowest = min(lowest, s.desired)
+			}
+		}
+		if lowest < d.Desired {
+			d.Desired = max(lowest, d.Current)
+			d.Suppressed = append(d.Suppressed, fmt.Sprintf(
+				"scale up stabilization: lowest recommendation over the last %v is %d", config.ScaleUpStabilization, lowest))
+		}
+	}
+
+	switch {
+	case d.Desired > d.Current:
+		added := scaledSince(now.Add(-config.ScaleUpRatePeriod), 1)
+		left := max(config.ScaleUpRateLimit-added, 0)
+		if d.Desired-d.Current > left {
+			d.Desired = d.Current + left
+			d.Suppressed = append(d.Suppressed, fmt.Sprintf(
+				"scale up rate limit: %d replicas added over the last %v, at most %d", added, config.ScaleUpRatePeriod, config.ScaleUpRateLimit))
+		}
+
+	case d.Desired < d.Current:
+		removed := scaledSince(now.Add(-config.ScaleDownRatePeriod), -1)
+		left := max(config.ScaleDownRateLimit-removed, 0)
+		if d.Current-d.Desired > left {
+			d.Desired = d.Current - left
+			d.Suppressed = append(d.Suppressed, fmt.Sprintf(
+				"scale down rate limit: %d replicas removed over the last %v, at most %d", removed, config.ScaleDownRatePeriod, config.ScaleDownRateLimit))
+		}
+		if d.Current-d.Desired > removable {
+			d.Desired = d.Current - removable
+			d.Suppressed = append(d.Suppressed, fmt.Sprintf(
+				"minimum lifetime: only %d replicas older than %v", removable, config.MinReplicaLifetime))
+		}
+	}
+}
+
+// scaledSince returns the replicas added (sign 1) or removed (sign -1) since the given time.
+// scalingStateMu must be held.
+func scaledSince(since time.Time, sign int) int {
+	n := 0
+	for _, e := range scaleEvents {
+		if e.at.After(since) && e.delta*sign > 0 {
+			n += e.delta * sign
+		}
+	}
+	return n
+}
+
+// recordScaleEvent keeps a scale operation for the rate limits. scalingStateMu must be held.
+func recordScaleEvent(at time.Time, delta int) {
+	if delta == 0 {
+		return
+	}
+	scaleEvents = append(scaleEvents, scaleEvent{at: at, delta: delta})
+	keep := max(config.ScaleUpRatePeriod, config.ScaleDownRatePeriod)
+	for len(scaleEvents) > 0 && at.Sub(scaleEvents[0].at) > keep {
+		scaleEvents = scaleEvents[1:]
+	}
+}
+
+// removableReplicas returns the replicas in rotation older than MinReplicaLifetime.
+func removableReplicas() int {
+	config.BackendsMu.Lock()
+	defer config.BackendsMu.Unlock()
+
+	n := 0
+	for _, b := range config.Backends {
+		if time.Since(b.StartTime) >= config.MinReplicaLifetime {
+			n++
+		}
+	}
+	return n
+}
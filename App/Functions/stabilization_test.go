@@ -0,0 +1,140 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+func TestStabilizeDecision(t *testing.T) {
+	type rec struct {
+		ago     time.Duration
+		desired int
+	}
+	type scaled struct {
+		ago   time.Duration
+		delta int
+	}
+	tests := []struct {
+		name             string
+		history          []rec
+		events           []scaled
+		current, desired int
+		removable        int
+		want             int
+		// suppressed lists the prefixes of the expected Suppressed reasons, in order
+		suppressed []string
+	}{
+		{name: "no change", current: 3, desired: 3, removable: 3, want: 3},
+		{
+			name:    "scale down held by a recent higher recommendation",
+			history: []rec{{4 * time.Minute, 5}}, current: 5, desired: 2, removable: 5,
+			want: 5, suppressed: []string{"scale down stabilization"},
+		},
+		{
+			name:    "held at the current count at most",
+			history: []rec{{time.Minute, 8}}, current: 5, desired: 2, removable: 5,
+			want: 5, suppressed: []string{"scale down stabilization"},
+		},
+		{
+			name:    "recommendations out of the window are forgotten",
+			history: []rec{{config.ScaleDownStabilization + time.Minute, 5}}, current: 2, desired: 1, removable: 2,
+			want: 1,
+		},
+		{
+			name:    "window then rate limit",
+			history: []rec{{2 * time.Minute, 4}}, current: 6, desired: 2, removable: 6,
+			want: 5, suppressed: []string{"scale down stabilization", "scale down rate limit"},
+		},
+		{
+			name:   "scale down rate limit used up",
+			events: []scaled{{30 * time.Second, -config.ScaleDownRateLimit}}, current: 4, desired: 3, removable: 4,
+			want: 4, suppressed: []string{"scale down rate limit"},
+		},
+		{
+			name:   "removals before the rate period don't count",
+			events: []scaled{{config.ScaleDownRatePeriod + time.Second, -1}}, current: 4, desired: 3, removable: 4,
+			want: 3,
+		},
+		{
+			name:   "additions don't count against the scale down rate",
+			events: []scaled{{10 * time.Second, 3}}, current: 4, desired: 3, removable: 4,
+			want: 3,
+		},
+		{
+			name:    "young replicas are kept",
+			current: 4, desired: 3, removable: 0,
+			want: 4, suppressed: []string{"minimum lifetime"},
+		},
+		{
+			name:    "scale up capped by the rate limit",
+			current: 1, desired: 10, removable: 1,
+			want: 1 + config.ScaleUpRateLimit, suppressed: []string{"scale up rate limit"},
+		},
+		{
+			name:   "scale up rate limit partly used",
+			events: []scaled{{30 * time.Second, config.ScaleUpRateLimit - 1}}, current: 2, desired: 6, removable: 2,
+			want: 3, suppressed: []string{"scale up rate limit"},
+		},
+		{
+			name:    "scale up isn't held by lower recommendations by default",
+			history: []rec{{10 * time.Second, 1}}, current: 2, desired: 4, removable: 2,
+			want: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+			scalingStateMu.Lock()
+			desiredHistory, scaleEvents = nil, nil
+			for _, r := range tt.history {
+				desiredHistory = append(desiredHistory, desiredSample{at: now.Add(-r.ago), desired: r.desired})
+			}
+			for _, e := range tt.events {
+				scaleEvents = append(scaleEvents, scaleEvent{at: now.Add(-e.ago), delta: e.delta})
+			}
+			scalingStateMu.Unlock()
+			t.Cleanup(func() {
+				scalingStateMu.Lock()
+				desiredHistory, scaleEvents = nil, nil
+				scalingStateMu.Unlock()
+			})
+
+			d := structers.ScalingDecision{Time: now, Current: tt.current, Desired: tt.desired}
+			stabilizeDecision(&d, tt.removable)
+			if d.Desired != tt.want {
+				t.Errorf("desired = %d, want %d (suppressed: %q)", d.Desired, tt.want, d.Suppressed)
+			}
+			if len(d.Suppressed) != len(tt.suppressed) {
+				t.Fatalf("suppressed = %q, want %d reasons starting with %q", d.Suppressed, len(tt.suppressed), tt.suppressed)
+			}
+			for i, prefix := range tt.suppressed {
+				if !strings.HasPrefix(d.Suppressed[i], prefix) {
+					t.Errorf("suppressed[%d] = %q, want it to start with %q", i, d.Suppressed[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordScaleEventForgetsPastTheRatePeriods(t *testing.T) {
+	scalingStateMu.Lock()
+	defer scalingStateMu.Unlock()
+	scaleEvents = nil
+	defer func() { scaleEvents = nil }()
+
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	keep := max(config.ScaleUpRatePeriod, config.ScaleDownRatePeriod)
+	recordScaleEvent(t0, 2)
+	recordScaleEvent(t0.Add(time.Second), 0)
+	recordScaleEvent(t0.Add(keep+time.Second), -1)
+	if len(scaleEvents) != 1 || scaleEvents[0].delta != -1 {
+		t.Errorf("scale events = %+v, want the last one only", scaleEvents)
+	}
+	if got := scaledSince(t0, -1); got != 1 {
+		t.Errorf("removed since t0 = %d, want 1", got)
+	}
+}
@@ -0,0 +1,198 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+func init() {
+	metrics.Describe("lb_replica_time_to_ready_seconds_total", metrics.Counter, "Total time the new replicas took to pass their startup probe.")
+	metrics.Describe("lb_replica_ready_total", metrics.Counter, "Replicas that passed their startup probe.")
+	metrics.Describe("lb_replica_time_to_ready_last_seconds", metrics.Gauge, "Time the last ready replica took to pass its startup probe.")
+	metrics.Describe("lb_replica_startup_failures_total", metrics.Counter, "Replicas destroyed because they didn't pass their startup probe in time.")
+}
+
+// registerStartingReplica files a freshly created backend as starting (counted against
+// MaxReplicas, out of rotation) and starts polling it with the startup probe of its pool.
+// attempt is the number of containers that already failed their startup before this one.
+func registerStartingReplica(b *structers.Backend, replacement bool, attempt int) error {
+	b.Alive = false
+	b.Ill = false
+	b.Starting = true
+	b.Replacement = replacement
+
+	config.BackendsMu.Lock()
+	total := containerCount()
+	if total >= config.MaxReplicas {
+		config.BackendsMu.Unlock()
+		return fmt.Errorf("cannot scale up beyond MaxReplicas (%d)", config.MaxReplicas)
+	}
+
+	config.Unhealthy = append(config.Unhealthy, b)
+	noteTransition(b, SourceScaling, probeResult{})
+	pending := len(config.Unhealthy)
+	config.BackendsMu.Unlock()
+
+	log.Printf("scale up in progress: now %d total replicas (pending: %d)", total+1, pending)
+
+	go awaitStartup(b, attempt)
+	return nil
+}
+
+// awaitStartup polls b every Startup.Interval until it answers or Startup.Timeout expires.
+// A backend that never got ready is destroyed and created again, with a backoff, until
+// the Retries of the spec are used up. While a dependency is down the replica likely waits
+// on it: the deadline is pushed back instead, degrade, don't kill.
+func awaitStartup(b *structers.Backend, attempt int) {
+	spec := healthSpecFor(b)
+	startup := spec.Startup
+	if startup.Interval <= 0 {
+		startup.Interval = config.DefaultHealthCheck.Startup.Interval
+	}
+	if startup.Timeout <= 0 {
+		startup.Timeout = config.DefaultHealthCheck.Startup.Timeout
+	}
+
+	deadline := b.StartTime.Add(startup.Timeout)
+	var last probeResult
+	for {
+		if !time.Now().Before(deadline) {
+			if !DependencyDegraded() {
+				break
+			}
+			log.Printf("startup: %s not ready yet, a dependency is down, waiting another %v", b.ContainerID, startup.Timeout)
+			deadline = time.Now().Add(startup.Timeout)
+		}
+		if atomic.LoadInt32(&b.ShuttingDown) == 1 {
+			// scaled down or replaced while starting, whoever did it cleans up
+			return
+		}
+
+		config.BackendsMu.Lock()
+		exited := b.Exited
+		config.BackendsMu.Unlock()
+		if exited {
+			last = probeResult{err: errors.New("container exited while starting")}
+			break
+		}
+
+		last = checkAlive(b)
+		if last.ok {
+			markReady(b, last)
+			return
+		}
+		time.Sleep(startup.Interval)
+	}
+
+	failStartup(b, last, startup, attempt)
+}
+
+// markReady brings a backend that passed its startup probe in rotation.
+func markReady(b *structers.Backend, res probeResult) {
+	took := time.Since(b.StartTime)
+
+	config.BackendsMu.Lock()
+	if atomic.LoadInt32(&b.ShuttingDown) == 1 {
+		config.BackendsMu.Unlock()
+		return
+	}
+	b.Starting = false
+	b.ReadyAfter = took
+	b.Alive = true
+	b.Ill = false
+	b.ConsecutiveOKs = 1
+	b.ConsecutiveFails = 0
+	b.NextProbe = nextProbeTime(healthSpecFor(b))
+	removeFromUnHealthy(b)
+	if !inHeap(b) {
+		heap.Push(&config.Backends, b)
+	}
+	if b.Replacement {
+		replacementHealthy(b)
+	}
+	noteTransition(b, SourceProbe, res)
+	config.BackendsMu.Unlock()
+
+	// release the requests queued while no backend was healthy
+	notifyBackendAvailable()
+
+	noteStartLatency(took)
+	metrics.Inc("lb_replica_ready_total")
+	metrics.Add("lb_replica_time_to_ready_seconds_total", took.Seconds())
+	metrics.Set("lb_replica_time_to_ready_last_seconds", took.Seconds())
+	log.Printf("Backend %s ready after %v", b.URL.String(), took.Round(time.Millisecond))
+}
+
+// failStartup destroys a backend that didn't pass its startup probe and, if retries are
+// left and the pool isn't crash-looping, creates another one after the backoff.
+func failStartup(b *structers.Backend, res probeResult, startup structers.StartupProbeSpec, attempt int) {
+	config.BackendsMu.Lock()
+	if atomic.LoadInt32(&b.ShuttingDown) == 1 {
+		config.BackendsMu.Unlock()
+		return
+	}
+	atomic.StoreInt32(&b.ShuttingDown, 1)
+	removeFromUnHealthy(b)
+	if inHeap(b) {
+		heap.Remove(&config.Backends, b.HeapIdx)
+	}
+	noteTransition(b, SourceProbe, res)
+	config.BackendsMu.Unlock()
+
+	if _, err := CloseReplicas(b.ContainerID); err != nil && !strings.Contains(err.Error(), "No such container") {
+		log.Printf("startup: closing %s failed: %v", b.ContainerID, err)
+	}
+
+	reason := fmt.Sprintf("not ready after %v", startup.Timeout)
+	if res.err != nil {
+		reason = fmt.Sprintf("%s: %v", reason, res.err)
+	}
+	metrics.Inc("lb_replica_startup_failures_total")
+	// a container dying during an outage of its dependencies isn't a sign of a bad image
+	if b.Replacement && !DependencyDegraded() {
+		recordReplacementFailure(b, reason)
+	} else {
+		emitEvent(EventReplicaFailed, b.Pool, reason, b.ContainerID)
+	}
+
+	if attempt >= startup.Retries {
+		log.Printf("startup: giving up on %s after %d attempts", b.Pool, attempt+1)
+		return
+	}
+	if CrashLooping() {
+		return
+	}
+
+	backoff := startup.Backoff << attempt
+	log.Printf("startup: retrying %s in %v (attempt %d/%d)", b.Pool, backoff, attempt+2, startup.Retries+1)
+	time.Sleep(backoff)
+
+	if DryRunEnabled(b.Pool) {
+		n := replicaCount()
+		emitDryRunEvent("retry", structers.Event{
+			Type:   EventReplicaCreated,
+			Pool:   b.Pool,
+			Reason: fmt.Sprintf("startup retry %d/%d", attempt+2, startup.Retries+1),
+			Before: n,
+			After:  n + 1,
+		})
+		return
+	}
+
+	scalingMutex.Lock()
+	defer scalingMutex.Unlock()
+	if _, err := startReplicaAttempt(b.Replacement, attempt+1); err != nil {
+		log.Printf("startup: retry failed: %v", err)
+	}
+}
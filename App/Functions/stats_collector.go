@@ -0,0 +1,254 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/metrics"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+// statsStream is the stats subscription of one replica and the samples it got.
+type statsStream struct {
+	cancel context.CancelFunc
+
+	// samples are the last StatsWindow ones, oldest first
+	samples []structers.ReplicaStats
+	updated time.Time
+	err     error
+}
+
+// replicaStatsView is what the admin API returns about the stats of a replica.
+type replicaStatsView struct {
+	ContainerID string    `json:"container_id"`
+	Samples     int       `json:"samples"`
+	Updated     time.Time `json:"updated"`
+	CPUAvg      float64   `json:"cpu_avg"`
+	CPUP95      float64   `json:"cpu_p95"`
+	MemoryAvg   float64   `json:"memory_avg"`
+	MemoryP95   float64   `json:"memory_p95"`
+
+	// ThrottledAvg is the average share of the CFS periods throttled, the rates are
+	// per second over the window
+	ThrottledAvg   float64 `json:"throttled_avg"`
+	NetRxRate      float64 `json:"net_rx_bytes_per_sec"`
+	NetTxRate      float64 `json:"net_tx_bytes_per_sec"`
+	BlockReadRate  float64 `json:"block_read_bytes_per_sec"`
+	BlockWriteRate float64 `json:"block_write_bytes_per_sec"`
+	PIDs           uint64  `json:"pids"`
+	PIDsLimit      uint64  `json:"pids_limit"`
+
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	// statsMu guards statsStreams and the streams in it
+	statsMu      sync.Mutex
+	statsStreams = make(map[string]*statsStream)
+)
+
+func init() {
+	metrics.GaugeFunc("lb_stats_streams", "Replicas with an open stats subscription.", func() float64 {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		return float64(len(statsStreams))
+	})
+	metrics.Describe("lb_stats_stream_errors_total", metrics.Counter, "Stats streams that dropped and were reopened.")
+}
+
+// CollectStats keeps one streaming stats subscription per replica, so the resource signal
+// reads its stats from memory instead of asking Docker for each replica in turn.
+// Meant to run in its own goroutine.
+func CollectStats() {
+	ticker := time.NewTicker(config.StatsSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		syncStatsStreams()
+		<-ticker.C
+	}
+}
+
+// syncStatsStreams subscribes to the replicas that have no stream yet and closes the
+// streams of the replicas gone.
+func syncStatsStreams() {
+	config.BackendsMu.Lock()
+	wanted := make(map[string]bool, config.Backends.Len()+len(config.Unhealthy))
+	for _, b := range config.Backends {
+		wanted[b.ContainerID] = true
+	}
+	for _, b := range config.Unhealthy {
+		if atomic.LoadInt32(&b.ShuttingDown) == 0 {
+			wanted[b.ContainerID] = true
+		}
+	}
+	config.BackendsMu.Unlock()
+	delete(wanted, "")
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	for id, st := range statsStreams {
+		if !wanted[id] {
+			st.cancel()
+			delete(statsStreams, id)
+		}
+	}
+	for id := range wanted {
+		if _, ok := statsStreams[id]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		st := &statsStream{cancel: cancel}
+		statsStreams[id] = st
+		go streamStats(ctx, id, st)
+	}
+}
+
+// streamStats follows the stats of a replica until its subscription is cancelled,
+// reopening the stream when it drops.
+func streamStats(ctx context.Context, containerID string, st *statsStream) {
+	for {
+		err := readStatsStream(ctx, containerID, st)
+		if ctx.Err() != nil {
+			return
+		}
+
+		statsMu.Lock()
+		st.err = err
+		statsMu.Unlock()
+		metrics.Inc("lb_stats_stream_errors_total")
+		log.Printf("stats stream of %s dropped: %v", containerID, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.StatsReconnectBackoff):
+		}
+	}
+}
+
+// readStatsStream decodes the samples of one stats stream into st until it fails.
+func readStatsStream(ctx context.Context, containerID string, st *statsStream) error {
+	body, err := replicaRuntime.Stats(ctx, containerID, true)
+	if err != nil {
+		return fmt.Errorf("open stats: %w", err)
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var s structers.StatsJSON
+		if err := dec.Decode(&s); err != nil {
+			return fmt.Errorf("decode stats: %w", err)
+		}
+		// the first sample of a stream has no previous cpu reading to compute a rate from
+		if s.PreCPUStats.SystemUsage == 0 {
+			continue
+		}
+		sample := replicaStatsFrom(&s)
+
+		statsMu.Lock()
+		st.samples = append(st.samples, *sample)
+		if len(st.samples) > config.StatsWindow {
+			st.samples = st.samples[len(st.samples)-config.StatsWindow:]
+		}
+		st.updated = time.Now()
+		st.err = nil
+		statsMu.Unlock()
+	}
+}
+
+// cachedStats returns the rolling usage of a replica from its stats stream.
+func cachedStats(containerID string) (replicaStatsView, error) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	v := replicaStatsView{ContainerID: containerID}
+	st, ok := statsStreams[containerID]
+	if !ok {
+		return v, errors.New("no stats subscription")
+	}
+	v.Samples, v.Updated = len(st.samples), st.updated
+	if st.err != nil {
+		v.Error = st.err.Error()
+	}
+	if len(st.samples) == 0 {
+		if st.err != nil {
+			return v, st.err
+		}
+		return v, errors.New("no stats sample yet")
+	}
+	if time.Since(st.updated) > config.StatsStaleAfter {
+		return v, fmt.Errorf("no stats sample for %v", time.Since(st.updated).Round(time.Second))
+	}
+
+	cpu := make([]float64, len(st.samples))
+	mem := make([]float64, len(st.samples))
+	throttled := 0.0
+	for i, s := range st.samples {
+		cpu[i], mem[i] = s.CPUPercent, s.MemoryPercent
+		throttled += s.ThrottledPercent
+	}
+	v.CPUAvg, v.CPUP95 = avgAndP95(cpu)
+	v.MemoryAvg, v.MemoryP95 = avgAndP95(mem)
+	v.ThrottledAvg = throttled / float64(len(st.samples))
+
+	first, last := st.samples[0], st.samples[len(st.samples)-1]
+	v.PIDs, v.PIDsLimit = last.PIDs, last.PIDsLimit
+	if secs := last.Read.Sub(first.Read).Seconds(); secs > 0 {
+		v.NetRxRate = counterRate(first.NetRxBytes, last.NetRxBytes, secs)
+		v.NetTxRate = counterRate(first.NetTxBytes, last.NetTxBytes, secs)
+		v.BlockReadRate = counterRate(first.BlockReadBytes, last.BlockReadBytes, secs)
+		v.BlockWriteRate = counterRate(first.BlockWriteBytes, last.BlockWriteBytes, secs)
+	}
+	return v, nil
+}
+
+// counterRate is the per second increase of a counter, 0 if it was reset in between.
+func counterRate(from, to uint64, secs float64) float64 {
+	if to < from {
+		return 0
+	}
+	return float64(to-from) / secs
+}
+
+// avgAndP95 returns the mean and the 95th percentile of values, sorting it.
+func avgAndP95(values []float64) (float64, float64) {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	slices.Sort(values)
+	return sum / float64(len(values)), values[(len(values)*95+99)/100-1]
+}
+
+// replicaStatsStatus returns the cached stats of every subscribed replica.
+func replicaStatsStatus() []replicaStatsView {
+	statsMu.Lock()
+	ids := make([]string, 0, len(statsStreams))
+	for id := range statsStreams {
+		ids = append(ids, id)
+	}
+	statsMu.Unlock()
+	slices.Sort(ids)
+
+	out := make([]replicaStatsView, 0, len(ids))
+	for _, id := range ids {
+		v, err := cachedStats(id)
+		if err != nil {
+			v.Error = err.Error()
+		}
+		out = append(out, v)
+	}
+	return out
+}
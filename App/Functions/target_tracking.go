@@ -0,0 +1,82 @@
+// Package functions implements core logic for active monitoring, load balancing,
+// and auto-scaling of back-end services.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xaydras-2/loadBalancer/App/config"
+	"github.com/xaydras-2/loadBalancer/App/structers"
+)
+
+var (
+	// latencyMu guards latencySamples and latencyCount
+	latencyMu sync.Mutex
+
+	// latencySamples is a ring of the latencies of the requests proxied since the last
+	// evaluation, latencyCount the number recorded in it (past its size the oldest are overwritten)
+	latencySamples = make([]time.Duration, config.MaxLatencySamples)
+	latencyCount   int
+)
+
+// proportionalReplicas returns the replica count bringing a per-replica metric from value
+// to target, assuming it spreads evenly: ceil(current * value / target). Within
+// TargetTolerance of the target the count is kept.
+func proportionalReplicas(current int, value, target float64) int {
+	ratio := value / target
+	if math.Abs(ratio-1) <= config.TargetTolerance {
+		return current
+	}
+	return int(math.Ceil(float64(current) * ratio))
+}
+
+// recordLatency keeps the latency of one proxied request for the latency signal.
+func recordLatency(d time.Duration) {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	// a full window is plenty for a p95, the oldest are overwritten
+	latencySamples[latencyCount%len(latencySamples)] = d
+	latencyCount++
+}
+
+// takeLatencyP95 returns the p95 of the latencies recorded since the last call and resets them.
+func takeLatencyP95() (time.Duration, int) {
+	latencyMu.Lock()
+	samples := slices.Clone(latencySamples[:min(latencyCount, len(latencySamples))])
+	latencyCount = 0
+	latencyMu.Unlock()
+
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	slices.Sort(samples)
+	return samples[(len(samples)*95+99)/100-1], len(samples)
+}
+
+// latencySignal tracks TargetP95Latency: a p95 twice the target asks for twice the replicas.
+type latencySignal struct{}
+
+func (latencySignal) Name() string { return "latency" }
+
+func (latencySignal) Recommend(current int) (structers.ScalingRecommendation, error) {
+	p95, n := takeLatencyP95()
+	if config.TargetP95Latency <= 0 {
+		return structers.ScalingRecommendation{}, errors.New("no latency target")
+	}
+	if n == 0 {
+		return structers.ScalingRecommendation{}, errors.New("no request proxied")
+	}
+	if current == 0 {
+		return structers.ScalingRecommendation{}, errors.New("no replica")
+	}
+
+	return structers.ScalingRecommendation{
+		Desired: proportionalReplicas(current, float64(p95), float64(config.TargetP95Latency)),
+		Reason:  fmt.Sprintf("p95=%v over %d reqs, target %v", p95.Round(time.Millisecond), n, config.TargetP95Latency),
+	}, nil
+}
@@ -22,6 +22,10 @@ var (
 	// used to determine scaling decisions based on request volume.
 	ReqCount int64
 
+	// LastRequestAt is the time (unix nano) of the last proxied request, updated atomically,
+	// used to tell when the pool has been idle long enough to scale to zero.
+	LastRequestAt = time.Now().UnixNano()
+
 	// NetworkName holds the Docker network name for service discovery.
 	NetworkName string
 
@@ -37,6 +41,67 @@ var (
 	// whenever a new backend is added, so it can perform an immediate health probe
 	// instead of waiting for the next periodic tick.
 	NewBackendTrigger = make(chan *structers.Backend, 10)
+
+	// ShadowPool lists the base URLs of the shadow back-ends (e.g. a new API build)
+	// that receive a mirrored copy of the live traffic. Leave it empty to disable mirroring.
+	ShadowPool = []string{}
+
+	// Mirror accumulates the primary/shadow comparison counters of the traffic mirroring.
+	Mirror structers.MirrorStats
+
+	// EventWebhooks receive every event (or the Types they ask for), e.g. for a chat-ops bot:
+	//	{Name: "chatops", URL: "https://bot.example/hooks/lb", SecretEnv: "LB_CHATOPS_SECRET"}
+	EventWebhooks = []structers.EventWebhook{}
+
+	// DryRun lists the pools starting in dry-run mode: their scaling decisions are logged
+	// and exported but no container is created or removed. Switchable from the admin API.
+	DryRun = map[string]bool{
+		ParentName: false,
+	}
+
+	// HealthChecks holds the health check spec of each pool, keyed by the pool (service) name.
+	// A pool without an entry uses DefaultHealthCheck.
+	HealthChecks = map[string]structers.HealthCheckSpec{
+		ParentName: DefaultHealthCheck,
+	}
+
+	// Dependencies are the services the api pool can't work without. While one of them is down
+	// the pool is "dependency degraded": its replicas are neither killed (unless they're gone
+	// for real) nor scaled, and the clients get the DegradedBody fallback.
+	Dependencies = []structers.Dependency{
+		{
+			Name:             "postgres",
+			Kind:             "postgres",
+			Address:          "localhost:5432",
+			User:             "postgres",
+			Database:         "test_lb",
+			ContainerName:    "postgres_db",
+			Interval:         5 * time.Second,
+			Timeout:          2 * time.Second,
+			FailureThreshold: 2,
+			RestartAfter:     time.Minute,
+			RestartCooldown:  5 * time.Minute,
+		},
+	}
+
+	// DefaultHealthCheck probes GET /healthz, any status below 400 is healthy,
+	// one failure makes a back-end ill and two in a row make it dead.
+	// A new back-end is polled every 500ms for up to a minute before it's recreated.
+	DefaultHealthCheck = structers.HealthCheckSpec{
+		Path:               "/healthz",
+		Method:             "GET",
+		Timeout:            5 * time.Second,
+		Interval:           ScaleInterval,
+		Jitter:             time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 2,
+		Startup: structers.StartupProbeSpec{
+			Interval: 500 * time.Millisecond,
+			Timeout:  60 * time.Second,
+			Retries:  3,
+			Backoff:  5 * time.Second,
+		},
+	}
 )
 
 const (
@@ -56,8 +121,20 @@ const (
 	MaxReplicas = 5
 
 	// MinReplicas sets the lower bound for auto-scaling.
+	// It can be 0: the last replica is then torn down after ScaleToZeroIdle without traffic,
+	// and the next request activates a new one (cold start).
 	MinReplicas = 1
 
+	// ScaleToZeroIdle is how long the pool must go without any request before its
+	// last replica is removed, only used when MinReplicas is 0.
+	ScaleToZeroIdle = 10 * time.Minute
+
+	// ActivationTimeout is how long requests are held while a replica cold starts from zero.
+	ActivationTimeout = 60 * time.Second
+
+	// ActivationProbeInterval is how often a cold start checks whether its replica got ready.
+	ActivationProbeInterval = 500 * time.Millisecond
+
 	// ScaleUpThreshold is the number of requests per interval
 	// that triggers scaling up additional replicas.
 	ScaleUpThreshold = 20 // requests per interval
@@ -74,10 +151,244 @@ const (
 	// putting it simply: "for every n sec do this"
 	ScaleIntervalAM = 33 * time.Second
 
+	// ThrottledHotPercent is the share of its CFS periods a replica can be throttled in before
+	// the AM counts it as hot, whatever its cpu: a container at its cpu quota never reads high.
+	ThrottledHotPercent = 25.0
+
+	// TargetRPSPerReplica, TargetP95Latency and TargetCPUPercent are the target-tracking
+	// goals of the scaling: the replica count is set so the per-replica rate, the p95 of
+	// the proxied requests or the average cpu usage lands on them. Zero disables a target,
+	// the rate and cpu ones then fall back to the ScaleUpThreshold/ScaleDownThreshold and
+	// the 75%/40% usage rules.
+	TargetRPSPerReplica = 50.0
+	TargetP95Latency    = 200 * time.Millisecond
+	TargetCPUPercent    = 60.0
+
+	// TargetTolerance is the relative gap to a target (0.1 = 10%) within which the
+	// replica count is left alone, so noise around the target doesn't move it.
+	TargetTolerance = 0.1
+
+	// MaxLatencySamples caps the request latencies kept between two scaling evaluations.
+	MaxLatencySamples = 10000
+
+	// TargetConcurrencyPerReplica is the average number of requests in flight per replica the
+	// concurrency signal sizes the pool for (the API saturates on concurrent db calls, not on
+	// rps), zero disables it. The in-flight load is sampled every LoadSampleInterval and
+	// averaged over the scaling interval.
+	TargetConcurrencyPerReplica = 8.0
+	LoadSampleInterval          = 100 * time.Millisecond
+
+	// ForecastEnabled turns on the predictive scaling: the request rate is recorded per
+	// ForecastBucket and a Holt-Winters model with a ForecastSeason period forecasts it,
+	// the pool is scaled ahead of the predicted peaks by the time a replica takes to start.
+	ForecastEnabled = true
+	ForecastBucket  = time.Minute
+	ForecastSeason  = 24 * time.Hour
+
+	// ForecastAlpha, ForecastBeta and ForecastGamma are the smoothing factors (0..1) of the
+	// level, the trend and the seasonal parts of the model.
+	ForecastAlpha = 0.3
+	ForecastBeta  = 0.05
+	ForecastGamma = 0.2
+
+	// DefaultStartLatency is the lead time of the forecast until a replica start was measured.
+	DefaultStartLatency = 30 * time.Second
+
+	// SchedulesPath points to the JSON file holding the scheduled scaling windows,
+	// reloaded every ScheduleReloadInterval when it changes.
+	SchedulesPath          = "./config/schedules.json"
+	ScheduleReloadInterval = 10 * time.Second
+
+	// ScheduleLookback is how far back the start of an open window is looked for, so the
+	// windows can't last longer than it (a weekly one is fine, a monthly one isn't).
+	ScheduleLookback = 8 * 24 * time.Hour
+
+	// ScheduleConvergeStep is the most replicas added or removed per evaluation when
+	// a window opening or closing moves the bounds, so the pool converges instead of jumping.
+	ScheduleConvergeStep = 1
+
+	// ScalingPolicy combines the recommendations of the scaling signals:
+	// "max" follows the signal asking for the most replicas (any signal can scale up,
+	// scaling down needs all of them to agree), "min" the one asking for the fewest.
+	ScalingPolicy = "max"
+
+	// ScaleUpCooldown is the minimum time between two scale ups.
+	ScaleUpCooldown = 30 * time.Second
+
+	// ScaleDownCooldown is the minimum time between a scale down and the previous scaling,
+	// whatever its direction, so a replica just added isn't removed right away.
+	ScaleDownCooldown = 60 * time.Second
+
+	// ScaleDownStabilization is the window over which the scaling recommendations are kept:
+	// a scale down only goes as low as the highest of them, so one quiet interval right
+	// after a burst doesn't remove the replicas just added.
+	ScaleDownStabilization = 5 * time.Minute
+
+	// ScaleUpStabilization does the same for scale ups with the lowest recommendation,
+	// zero reacts to a burst right away.
+	ScaleUpStabilization time.Duration = 0
+
+	// ScaleUpRateLimit and ScaleDownRateLimit are the most replicas added, removed,
+	// per ScaleUpRatePeriod and ScaleDownRatePeriod.
+	ScaleUpRateLimit    = 4
+	ScaleUpRatePeriod   = time.Minute
+	ScaleDownRateLimit  = 1
+	ScaleDownRatePeriod = time.Minute
+
+	// ScaleDownVictimPolicy picks the replicas removed by a scale down:
+	// "newest", "oldest", "least_loaded" or "highest_usage" (cpu, then memory).
+	ScaleDownVictimPolicy = "least_loaded"
+
+	// DrainTimeout is how long a replica being removed gets to finish its in-flight requests,
+	// polled every DrainPollInterval, past it the container is stopped anyway.
+	DrainTimeout      = 30 * time.Second
+	DrainPollInterval = 100 * time.Millisecond
+
+	// MinReplicaLifetime is how long a replica is kept at least before a scale down may remove it.
+	MinReplicaLifetime = 3 * time.Minute
+
+	// ScaleConcurrency caps the containers created or removed at the same time
+	// when the pool scales by several replicas at once.
+	ScaleConcurrency = 3
+
+	// MaxScalingDecisions is how many scaling decisions are kept for the admin API.
+	MaxScalingDecisions = 100
+
 	// DockerComposePath points to the Docker Compose file
 	// used to spawn and manage containers.
 	DockerComposePath = "../API/docker-compose.yaml"
 
-	// StartupGracePeriod indicates the period in which an x api must be full woken up
-	StartupGracePeriod = 10 * time.Second
+	// AdminAddr is the address of the admin server (stats, runtime switches),
+	// kept apart from the proxied traffic so it can't collide with the api routes.
+	AdminAddr = ":9090"
+
+	// MirrorSampleRate is the share (0..1) of live requests copied to the ShadowPool.
+	MirrorSampleRate = 0.1
+
+	// MirrorMaxBodySize is the largest request body (in bytes) buffered for mirroring,
+	// requests with a bigger body are served normally but not mirrored.
+	MirrorMaxBodySize = 1 << 20
+
+	// MirrorTimeout bounds a mirrored request, its response is always discarded.
+	MirrorTimeout = 5 * time.Second
+
+	// RateLimitPath points to the JSON file holding the rate limit rules,
+	// a missing file means no limits at all.
+	RateLimitPath = "./config/ratelimits.json"
+
+	// RateLimitReloadInterval is how often the rate limit file is checked for changes,
+	// so the rules can be edited without a restart.
+	RateLimitReloadInterval = 10 * time.Second
+
+	// RateLimitBucketTTL is how long an unused bucket is kept before being evicted.
+	RateLimitBucketTTL = 10 * time.Minute
+
+	// APIKeyHeader is the request header carrying the client API key.
+	APIKeyHeader = "X-API-Key"
+
+	// ConcurrencyLimiterEnabled turns on the adaptive in-flight limit at the front door.
+	ConcurrencyLimiterEnabled = true
+
+	// ConcurrencyInitialLimit is the in-flight limit used before any latency is observed.
+	ConcurrencyInitialLimit = 20
+
+	// ConcurrencyMinLimit and ConcurrencyMaxLimit bound the discovered in-flight limit.
+	ConcurrencyMinLimit = 2
+	ConcurrencyMaxLimit = 1000
+
+	// ConcurrencyMaxQueue is how many requests may wait for a free slot, the next ones are rejected.
+	ConcurrencyMaxQueue = 200
+
+	// ConcurrencyQueueTimeout is how long a request waits for a free slot before being rejected.
+	ConcurrencyQueueTimeout = 2 * time.Second
+
+	// BackendQueueEnabled makes requests wait for a healthy backend instead of getting
+	// an immediate 503 when the pool is empty (e.g. while a replica is starting).
+	BackendQueueEnabled = true
+
+	// BackendQueueMaxLength is how many requests may wait for a backend, the next ones get a 503.
+	BackendQueueMaxLength = 100
+
+	// BackendQueueTimeout is how long a single request waits for a backend.
+	BackendQueueTimeout = 10 * time.Second
+
+	// BackendQueueOrder is the release order of the waiting requests: "fifo" or "priority".
+	BackendQueueOrder = "fifo"
+
+	// BackendQueuePriorityHeader carries the request priority in "priority" order, higher goes first.
+	BackendQueuePriorityHeader = "X-Priority"
+
+	// QueueTimeHeader is the response header reporting how long the request waited for a backend.
+	QueueTimeHeader = "X-LB-Queue-Time"
+
+	// RemediationInterval is how often the dead replicas are looked for and replaced.
+	RemediationInterval = 10 * time.Second
+
+	// ReplaceDeadAfter is how long a replica stays dead before it's closed and replaced.
+	ReplaceDeadAfter = 60 * time.Second
+
+	// DeadProbeMaxBackoff caps the exponential backoff between two probes of a dead replica.
+	DeadProbeMaxBackoff = 5 * time.Minute
+
+	// CrashLoopThreshold is the number of failed replacements in a row that flags the pool
+	// as crash-looping, which stops any further replacement until it's reset from the admin API.
+	CrashLoopThreshold = 3
+
+	// DockerEventsReconnectMin and DockerEventsReconnectMax bound the backoff between two
+	// reconnections to the Docker events stream.
+	DockerEventsReconnectMin = time.Second
+	DockerEventsReconnectMax = 30 * time.Second
+
+	// StatsSyncInterval is how often the stats collector subscribes to the stats stream of the
+	// new replicas and drops the ones of the removed replicas. Docker sends a sample per second,
+	// the last StatsWindow ones are kept per replica for the averages and percentiles, and a
+	// replica without a sample for StatsStaleAfter has no stats until its stream recovers.
+	StatsSyncInterval = 2 * time.Second
+	StatsWindow       = 30
+	StatsStaleAfter   = 10 * time.Second
+
+	// StatsReconnectBackoff is the wait before reopening a dropped stats stream.
+	StatsReconnectBackoff = 2 * time.Second
+
+	// HealthCheckTick is how often the health checker looks for backends due for a probe,
+	// each backend is then probed on the Interval (plus Jitter) of its pool spec.
+	HealthCheckTick = 500 * time.Millisecond
+
+	// HealthCheckWorkers caps the number of probes running at the same time.
+	HealthCheckWorkers = 8
+
+	// DegradedStatus, DegradedContentType and DegradedBody make the fallback response
+	// served while a dependency is down.
+	DegradedStatus      = 503
+	DegradedContentType = "application/json"
+	DegradedBody        = `{"error":"service temporarily unavailable, a dependency is down"}`
+
+	// DegradedRetryAfter is the Retry-After (in seconds) of the fallback response.
+	DegradedRetryAfter = 30
+
+	// MaxHealthHistory is how many health transitions are kept per backend.
+	MaxHealthHistory = 50
+
+	// RetiredHistories is how many backends gone from the pool (scaled down, replaced,
+	// failed startup) keep their health history queryable, for at most RetiredHistoryTTL.
+	RetiredHistories  = 100
+	RetiredHistoryTTL = time.Hour
+
+	// MaxEvents is how many lifecycle events are kept for the admin API.
+	MaxEvents = 200
+
+	// EventLogPath is the JSONL file every event is appended to, empty disables it. It's
+	// rotated once bigger than EventLogMaxSize, keeping EventLogBackups old files (.1 the newest).
+	EventLogPath    = "./Logs/events.jsonl"
+	EventLogMaxSize = 10 << 20
+	EventLogBackups = 5
+
+	// WebhookQueueSize is how many events wait for delivery per webhook, past it they're dropped.
+	WebhookQueueSize = 256
+
+	// WebhookTimeout bounds one delivery attempt, WebhookMaxAttempts is the number of attempts
+	// per event, spaced by WebhookBackoff doubled each time.
+	WebhookTimeout     = 5 * time.Second
+	WebhookMaxAttempts = 5
+	WebhookBackoff     = time.Second
 )
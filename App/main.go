@@ -19,21 +19,43 @@ func main() {
 	// 1. Start initial replicas
 	functions.CallContainers()
 
-	// 2. Start auto-scaler
+	// 2. Start the scaling controller (request volume and active monitoring (AM) signals)
 	go functions.AutoScaler()
-	// 2.1 Start the active monitoring (AM) load balancer
-	go functions.AMLB()
+	// and sample the in-flight load for its concurrency signal
+	go functions.SampleLoad()
+	// and stream the stats of the replicas for its resource signal
+	go functions.CollectStats()
 
 	// start the health checking
 	go functions.StartHealthChecker()
+	// and the replacement of the replicas that stay dead
+	go functions.StartRemediation()
+	// react to the replicas dying as soon as Docker reports it
+	go functions.WatchDockerEvents()
+	// watch the dependencies (db) so their outage isn't blamed on the replicas
+	functions.StartDependencyMonitor()
+
+	// load the rate limit rules and keep them in sync with the file
+	go functions.WatchRateLimits()
+
+	// and the scheduled scaling windows
+	go functions.WatchSchedules()
+
+	// hand the backends over to the requests queued while none was healthy
+	go functions.DispatchQueuedRequests()
+
+	// deliver the events to the configured webhooks
+	go functions.DeliverWebhooks()
 
 	// 3. HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// rate limited requests are rejected before being counted, so a flood can't trigger a scale up
+	mux.HandleFunc("/", functions.RateLimit(functions.ConcurrencyLimit(func(w http.ResponseWriter, r *http.Request) {
 		// increment atomically
 		atomic.AddInt64(&config.ReqCount, 1)
+		atomic.StoreInt64(&config.LastRequestAt, time.Now().UnixNano())
 		functions.ProxyHandler()(w, r)
-	})
+	})))
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -51,6 +73,18 @@ func main() {
 		}
 	}()
 
+	adminSrv := &http.Server{
+		Addr:    config.AdminAddr,
+		Handler: functions.AdminHandler(),
+	}
+
+	go func() {
+		log.Printf("Admin server running on %s", config.AdminAddr)
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin server error: %v", err)
+		}
+	}()
+
 	go func() {
 		for {
 			config.BackendsMu.Lock()
@@ -80,6 +114,9 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("HTTP shutdown error: %v", err)
 	}
+	if err := adminSrv.Shutdown(ctx); err != nil {
+		log.Printf("admin shutdown error: %v", err)
+	}
 
 	// 5. Tear down containers
 	log.Println("Stopping backend containers…")
@@ -0,0 +1,141 @@
+// Package metrics is a tiny in-process registry of counters and gauges,
+// exposed in the Prometheus text format on the admin server.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Counter is a value that only goes up.
+	Counter = "counter"
+	// Gauge is a value that can go up and down.
+	Gauge = "gauge"
+)
+
+// family groups all the series sharing the same metric name.
+type family struct {
+	kind   string
+	help   string
+	series map[string]float64 // rendered labels -> value
+	fn     func() float64     // when set, the unlabelled value is read at scrape time
+}
+
+var (
+	mu       sync.Mutex
+	families = map[string]*family{}
+)
+
+// Describe registers the type and help text of a metric, it's optional:
+// an undescribed metric is exported as untyped.
+func Describe(name, kind, help string) {
+	mu.Lock()
+	defer mu.Unlock()
+	f := get(name)
+	f.kind, f.help = kind, help
+}
+
+// Inc adds one to the counter name, labels are given as key, value pairs.
+func Inc(name string, labels ...string) {
+	Add(name, 1, labels...)
+}
+
+// Add adds delta to the series name{labels}.
+func Add(name string, delta float64, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	get(name).series[renderLabels(labels)] += delta
+}
+
+// Set overwrites the value of the series name{labels}.
+func Set(name string, value float64, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	get(name).series[renderLabels(labels)] = value
+}
+
+// Delete drops the series name{labels}, used when the labelled object is gone.
+func Delete(name string, labels ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if f, ok := families[name]; ok {
+		delete(f.series, renderLabels(labels))
+	}
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape,
+// for state that already lives somewhere else (e.g. a queue length).
+// fn runs under the registry lock and must not call back into this package.
+func GaugeFunc(name, help string, fn func() float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	f := get(name)
+	f.kind, f.help, f.fn = Gauge, help, fn
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, name := range names {
+			f := families[name]
+			if f.help != "" {
+				fmt.Fprintf(w, "# HELP %s %s\n", name, f.help)
+			}
+			if f.kind != "" {
+				fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+			}
+			if f.fn != nil {
+				fmt.Fprintf(w, "%s %g\n", name, f.fn())
+			}
+			keys := make([]string, 0, len(f.series))
+			for k := range f.series {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(w, "%s%s %g\n", name, k, f.series[k])
+			}
+		}
+	})
+}
+
+// get returns the family of name, creating it if needed. mu must be held.
+func get(name string) *family {
+	f, ok := families[name]
+	if !ok {
+		f = &family{series: map[string]float64{}}
+		families[name] = f
+	}
+	return f
+}
+
+// renderLabels turns key, value pairs into {k1="v1",k2="v2"}.
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=%q", labels[i], labels[i+1])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
@@ -37,4 +37,40 @@ type Backend struct {
 	// StartTime save the time of initialization of a container,
 	// used to give a warm up phase to an api to start up
 	StartTime time.Time
+
+	// Pool is the name of the service this back-end belongs to, it selects its health check spec.
+	Pool string
+
+	// ConsecutiveOKs and ConsecutiveFails count the probes in a row with the same outcome,
+	// compared against the HealthyThreshold/UnhealthyThreshold of the pool.
+	ConsecutiveOKs   int
+	ConsecutiveFails int
+
+	// DeadSince is when the back-end was marked dead, zero while it isn't.
+	DeadSince time.Time
+
+	// NextProbe and ProbeBackoff space out the probes of a dead back-end exponentially.
+	NextProbe    time.Time
+	ProbeBackoff time.Duration
+
+	// Exited is set once the Docker events report the container stopped (die, oom, kill, destroy),
+	// the remediation loop replaces it without waiting for ReplaceDeadAfter.
+	Exited bool
+
+	// Replacement is set on a back-end created by the remediation loop to replace a dead one.
+	Replacement bool
+
+	// Draining is set while a scale down waits for the in-flight requests of the back-end,
+	// it's out of rotation already.
+	Draining bool
+
+	// Starting is set until the back-end passed its startup probe, the health checker leaves it alone meanwhile.
+	Starting bool
+
+	// ReadyAfter is the time it took the back-end to pass its startup probe.
+	ReadyAfter time.Duration
+
+	// State is the last recorded health state, History the bounded list of its changes.
+	State   string
+	History []HealthTransition
 }
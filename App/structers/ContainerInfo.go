@@ -0,0 +1,24 @@
+package structers
+
+import "time"
+
+// ContainerInfo is what a runtime reports about one container.
+type ContainerInfo struct {
+	ID   string
+	Name string
+
+	// State is "created", "running", "exited"... as Docker names them.
+	State string
+
+	Labels map[string]string
+
+	// Ports maps the published container ports to their host port.
+	Ports map[string]string
+}
+
+// ContainerEvent is a lifecycle event of a container ("die", "oom", "health_status: unhealthy"...).
+type ContainerEvent struct {
+	ID     string
+	Action string
+	Time   time.Time
+}
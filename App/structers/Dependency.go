@@ -0,0 +1,37 @@
+package structers
+
+import "time"
+
+// Dependency describes a service the pool relies on (e.g. its database), monitored so
+// its outage isn't mistaken for the replicas being broken.
+type Dependency struct {
+	// Name identifies the dependency in the logs, metrics and admin API.
+	Name string
+
+	// Kind is the probe used: "tcp" (connect only) or "postgres" (protocol startup handshake).
+	Kind string
+
+	// Address is the host:port probed.
+	Address string
+
+	// User and Database are sent in the postgres startup message.
+	User     string
+	Database string
+
+	// ContainerName is the Docker container running the dependency, used to restart it.
+	ContainerName string
+
+	// Interval is the time between two probes, Timeout bounds one probe.
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// FailureThreshold is the number of failed probes in a row marking the dependency down.
+	FailureThreshold int
+
+	// RestartAfter restarts the container once the dependency has been down that long,
+	// zero disables the automatic restart (it can still be done from the admin API).
+	RestartAfter time.Duration
+
+	// RestartCooldown is the minimum time between two automatic restarts.
+	RestartCooldown time.Duration
+}
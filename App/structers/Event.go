@@ -0,0 +1,26 @@
+package structers
+
+import "time"
+
+// Event records one scaling or lifecycle action of the load balancer (a scale up, a replica
+// replaced, a pool flagged as crash-looping...), kept for the admin API, appended to the
+// event log and sent to the webhooks.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Pool        string    `json:"pool"`
+	Reason      string    `json:"reason"`
+	ContainerID string    `json:"container_id,omitempty"`
+
+	// Before and After are the replicas of the pool (ready and starting) before and after
+	// the action, the same for the events that don't change it.
+	Before int `json:"before"`
+	After  int `json:"after"`
+
+	// Duration is how long the action took, Error why it (partly) failed.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// DryRun marks the events of the operations a dry-run pool only reported.
+	DryRun bool `json:"dry_run,omitempty"`
+}
@@ -0,0 +1,17 @@
+package structers
+
+// EventWebhook is an HTTP endpoint the events are POSTed to as JSON.
+type EventWebhook struct {
+	// Name identifies the webhook in the logs and the metrics.
+	Name string
+
+	// URL receives one POST per event.
+	URL string
+
+	// SecretEnv names the environment variable holding the HMAC-SHA256 key the body is
+	// signed with (X-LB-Signature header), the requests aren't signed when it's empty.
+	SecretEnv string
+
+	// Types restricts the events sent to these types, every event when empty.
+	Types []string
+}
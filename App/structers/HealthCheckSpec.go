@@ -0,0 +1,64 @@
+package structers
+
+import "time"
+
+// HealthCheckSpec describes how the back-ends of a pool are probed and
+// when a probe result flips their health state.
+type HealthCheckSpec struct {
+	// Path is the URL path probed on the back-end, e.g. "/healthz".
+	Path string
+
+	// Method is the HTTP method of the probe, GET when empty.
+	Method string
+
+	// Headers are added to every probe request.
+	Headers map[string]string
+
+	// ExpectedStatus lists the status codes meaning healthy, empty means any status below 400.
+	ExpectedStatus []int
+
+	// BodyRegex, when set, must match the response body.
+	BodyRegex string
+
+	// JSONField, when set, is a dotted path (e.g. "checks.db") into the JSON body
+	// whose value must equal JSONValue.
+	JSONField string
+	JSONValue string
+
+	// Timeout bounds a single probe.
+	Timeout time.Duration
+
+	// Interval is the time between two probing sweeps, Jitter is a random extra delay
+	// up to it, so the probes of several balancers don't line up.
+	Interval time.Duration
+	Jitter   time.Duration
+
+	// HealthyThreshold is the number of consecutive successful probes needed to bring
+	// a back-end (back) in rotation.
+	HealthyThreshold int
+
+	// UnhealthyThreshold is the number of consecutive failed probes marking a back-end dead,
+	// the first failure already marks it ill.
+	UnhealthyThreshold int
+
+	// Startup is the probe a new back-end must pass before it joins the rotation.
+	Startup StartupProbeSpec
+}
+
+// StartupProbeSpec describes how a freshly created back-end is polled until it's ready.
+// It uses the Path, Method, Headers and matching rules of the HealthCheckSpec holding it.
+type StartupProbeSpec struct {
+	// Interval is the time between two startup probes, kept short so a replica
+	// takes traffic as soon as it answers.
+	Interval time.Duration
+
+	// Timeout is how long a back-end has to pass its startup probe, past it the
+	// container is destroyed and another one is created.
+	Timeout time.Duration
+
+	// Retries is the number of containers created again after a failed startup, zero gives up at once.
+	Retries int
+
+	// Backoff is the wait before the first retry, doubled on each following one.
+	Backoff time.Duration
+}
@@ -0,0 +1,21 @@
+package structers
+
+import "time"
+
+// HealthTransition records one change of health state of a back-end.
+type HealthTransition struct {
+	Time time.Time `json:"time"`
+
+	// From and To are the states before and after the change:
+	// "starting", "alive", "ill", "dead", "draining" or "shutting_down".
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Source is the subsystem that made the change: "probe", "passive" or "scaling".
+	Source string `json:"source"`
+
+	// Latency, StatusCode and Error describe the probe behind the change, when there's one.
+	Latency    time.Duration `json:"latency_ns,omitempty"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
@@ -0,0 +1,24 @@
+package structers
+
+// MirrorStats holds the counters of the traffic mirroring, comparing the primary
+// responses against the shadow ones. All fields are updated atomically.
+type MirrorStats struct {
+	// Mirrored is the number of requests copied to the shadow pool.
+	Mirrored int64 `json:"mirrored"`
+
+	// Skipped counts sampled requests not mirrored because their body exceeded MirrorMaxBodySize.
+	Skipped int64 `json:"skipped"`
+
+	// Failed counts mirrored requests that got no response from the shadow pool.
+	Failed int64 `json:"failed"`
+
+	// Compared is the number of requests where both the primary and the shadow answered.
+	Compared int64 `json:"compared"`
+
+	// StatusMismatch counts compared requests where the status codes differ.
+	StatusMismatch int64 `json:"status_mismatch"`
+
+	// LatencyDiffNs is the sum of (shadow latency - primary latency) in nanoseconds,
+	// divided by Compared it gives the average slowdown of the shadow build.
+	LatencyDiffNs int64 `json:"latency_diff_ns"`
+}
@@ -0,0 +1,9 @@
+package structers
+
+// NetworkSpec describes a network the containers join, as declared in the compose file.
+type NetworkSpec struct {
+	Name    string
+	Driver  string
+	Options map[string]string
+	Labels  map[string]string
+}
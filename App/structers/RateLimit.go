@@ -0,0 +1,37 @@
+package structers
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitRule describes one token bucket limit, as read from config.RateLimitPath.
+type RateLimitRule struct {
+	// Name identifies the rule in the logs and the metrics.
+	Name string `json:"name"`
+
+	// Key selects what the buckets are keyed by:
+	// "ip" (client address), "header:<Name>" (value of a request header),
+	// "apikey" (value of config.APIKeyHeader) or "route" (the matched route prefix).
+	Key string `json:"key"`
+
+	// Route restricts the rule to paths starting with it, empty means every path.
+	Route string `json:"route,omitempty"`
+
+	// Rate is the number of tokens added per second.
+	Rate float64 `json:"rate"`
+
+	// Burst is the capacity of a bucket, the most requests allowed at once.
+	Burst int `json:"burst"`
+}
+
+// TokenBucket holds the tokens left for one key of a rule.
+type TokenBucket struct {
+	Mutex sync.Mutex
+
+	// Tokens is the amount of tokens left, refilled lazily on each take.
+	Tokens float64
+
+	// Last is when Tokens was last refilled, also used to evict idle buckets.
+	Last time.Time
+}
@@ -0,0 +1,22 @@
+package structers
+
+// ReplicaSpec describes a container to create, whatever runtime runs it.
+type ReplicaSpec struct {
+	// Name is the container name, it must be unique.
+	Name string
+
+	// Image is the image run, pulled first if missing.
+	Image string
+
+	// Cmd and Env are passed to the container, Labels set on it.
+	Cmd    []string
+	Env    []string
+	Labels map[string]string
+
+	// Port is the container tcp port published on HostPort, any free one when empty.
+	Port     string
+	HostPort string
+
+	// Network is the network the container joins.
+	Network string
+}
@@ -1,8 +1,27 @@
 package structers
 
+import "time"
+
 type ReplicaStats struct {
-	CPUPercent    float64 // CPU usage %
-	MemoryUsage   uint64  // Memory used in bytes
+	Read time.Time // when Docker took the sample
+
+	CPUPercent    float64 // CPU usage %, 100% per fully used cpu
+	OnlineCPUs    uint32  // cpus available to the container
+	MemoryUsage   uint64  // Memory used in bytes, page cache excluded
 	MemoryLimit   uint64  // Memory limit in bytes
 	MemoryPercent float64 // Memory usage %
+
+	ThrottledPercent float64 // share of the CFS periods throttled since the previous sample
+	ThrottledTime    uint64  // total time throttled, in nanoseconds
+
+	NetRxBytes   uint64 // bytes received, all interfaces
+	NetTxBytes   uint64 // bytes sent, all interfaces
+	NetRxDropped uint64 // incoming packets dropped
+	NetTxDropped uint64 // outgoing packets dropped
+
+	BlockReadBytes  uint64 // bytes read from block devices
+	BlockWriteBytes uint64 // bytes written to block devices
+
+	PIDs      uint64 // processes and threads in the container
+	PIDsLimit uint64 // 0 when unlimited
 }
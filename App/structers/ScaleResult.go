@@ -0,0 +1,14 @@
+package structers
+
+// ScaleResult reports the outcome of a scale operation on several replicas: what was
+// done is kept even when part of it failed.
+type ScaleResult struct {
+	// Requested is the number of replicas asked to be added or removed.
+	Requested int `json:"requested"`
+
+	// Succeeded lists the containers created (still starting) or removed.
+	Succeeded []string `json:"succeeded,omitempty"`
+
+	// Failed lists why the other replicas couldn't be added or removed.
+	Failed []string `json:"failed,omitempty"`
+}
@@ -0,0 +1,46 @@
+package structers
+
+import "time"
+
+// ScalingRecommendation is the replica count one scaling signal asks for.
+type ScalingRecommendation struct {
+	Signal  string `json:"signal"`
+	Desired int    `json:"desired"`
+	Reason  string `json:"reason"`
+
+	// Error is set when the signal couldn't be computed, the recommendation is then ignored.
+	Error string `json:"error,omitempty"`
+}
+
+// ScalingDecision records one evaluation of the scaling controller: what every signal
+// asked for, which one won under the policy, and what was done about it.
+type ScalingDecision struct {
+	Time   time.Time `json:"time"`
+	Pool   string    `json:"pool"`
+	Policy string    `json:"policy"`
+
+	// Current is the replica count (ready and starting) at the evaluation, Recommended the
+	// count picked by the policy once clamped to the pool bounds, and Desired what's left
+	// of it after the stabilization windows, the rate limits and the replica lifetime.
+	Current     int `json:"current"`
+	Recommended int `json:"recommended"`
+	Desired     int `json:"desired"`
+
+	// Winner is the signal whose recommendation was picked, empty when none was usable.
+	Winner string `json:"winner,omitempty"`
+
+	// Action is "scale_up", "scale_down" or "none", Reason explains it.
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+
+	// Suppressed lists why the decision was held back from the recommended count.
+	Suppressed []string `json:"suppressed,omitempty"`
+
+	Recommendations []ScalingRecommendation `json:"recommendations"`
+
+	// DryRun is set when the pool was in dry-run mode, the action was only logged.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Result is the outcome of the scale operation, nil when nothing was done.
+	Result *ScaleResult `json:"result,omitempty"`
+}
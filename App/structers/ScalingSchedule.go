@@ -0,0 +1,26 @@
+package structers
+
+// ScalingSchedule is a recurring window overriding the replica bounds of the pool,
+// as read from config.SchedulesPath.
+type ScalingSchedule struct {
+	// Name identifies the window in the logs, the metrics and the admin API.
+	Name string `json:"name"`
+
+	// Start and End are 5 field cron expressions (minute hour day-of-month month day-of-week),
+	// the window is open from a Start match until the following End match,
+	// e.g. "0 8 * * 1-5" to "0 18 * * 1-5" for the weekdays business hours.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Timezone is the IANA zone the cron expressions are read in, UTC when empty.
+	Timezone string `json:"timezone,omitempty"`
+
+	// MinReplicas and MaxReplicas replace the pool bounds while the window is open,
+	// a nil one leaves that bound alone.
+	MinReplicas *int `json:"min_replicas,omitempty"`
+	MaxReplicas *int `json:"max_replicas,omitempty"`
+
+	// Priority settles overlapping windows: each bound comes from the open window with the
+	// highest priority setting it, the first declared one on a tie.
+	Priority int `json:"priority,omitempty"`
+}